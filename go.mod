@@ -5,7 +5,9 @@ go 1.24
 toolchain go1.24.3
 
 require (
+	github.com/quic-go/quic-go v0.54.0
 	github.com/refraction-networking/utls v1.7.3
+	golang.org/x/crypto v0.39.0
 	golang.org/x/net v0.41.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -14,6 +16,11 @@ require (
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/mod v0.25.0 // indirect
+	golang.org/x/sync v0.15.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	golang.org/x/tools v0.33.0 // indirect
 )
@@ -7,15 +7,63 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
 
 	"gopkg.in/yaml.v2"
 )
 
 // IncludeEntry represents an entry in the include_list section of the configuration.
-// It specifies a target host and an optional protocol.
+// It specifies a target host, an optional protocol and, for the "custom" protocol,
+// an optional send/expect script that runs before the TLS handshake.
+// EnumerateTLSVersions, EnumerateCipherSuites and DetectKeyExchange enable the respective
+// TLS analysis for the entry's targets only. HandshakeProfiles overrides the global profiles.
 type IncludeEntry struct {
-	Target   string `yaml:"target"`
-	Protocol string `yaml:"protocol,omitempty"`
+	Target                string       `yaml:"target"`
+	Protocol              string       `yaml:"protocol,omitempty"`
+	Script                []ScriptStep `yaml:"script,omitempty"`
+	EnumerateTLSVersions  bool         `yaml:"enumerate_tls_versions,omitempty"`
+	EnumerateCipherSuites bool         `yaml:"enumerate_cipher_suites,omitempty"`
+	DetectKeyExchange     bool         `yaml:"detect_key_exchange,omitempty"`
+	HandshakeProfiles     []string     `yaml:"handshake_profiles,omitempty"`
+	MultiSNI              bool         `yaml:"multi_sni,omitempty"`
+	SNICandidates         []string     `yaml:"sni_candidates,omitempty"`
+	CheckRevocation       bool         `yaml:"check_revocation,omitempty"`
+}
+
+// ScriptStep is a single step of a custom protocol script. Exactly one field must be set:
+// Send writes raw data, Expect reads lines until one matches the regular expression,
+// and TLS marks the start of the TLS handshake (only valid as the last step).
+type ScriptStep struct {
+	Send   string `yaml:"send,omitempty"`
+	Expect string `yaml:"expect,omitempty"`
+	TLS    bool   `yaml:"tls,omitempty"`
+}
+
+// ValidateScript checks the grammar of a custom protocol script.
+// Returns an error describing the first invalid step.
+func ValidateScript(steps []ScriptStep) error {
+	for i, step := range steps {
+		actions := 0
+		if step.Send != "" {
+			actions++
+		}
+		if step.Expect != "" {
+			actions++
+			if _, err := regexp.Compile(step.Expect); err != nil {
+				return fmt.Errorf("step %d: invalid expect regex: %w", i+1, err)
+			}
+		}
+		if step.TLS {
+			actions++
+			if i != len(steps)-1 {
+				return fmt.Errorf("step %d: tls must be the last step", i+1)
+			}
+		}
+		if actions != 1 {
+			return fmt.Errorf("step %d: exactly one of send, expect or tls must be set", i+1)
+		}
+	}
+	return nil
 }
 
 // ExcludeCertRule represents a rule for excluding certificates based on issuer or CN.
@@ -28,25 +76,36 @@ type ExcludeCertRule struct {
 // Config represents the application's configuration loaded from a YAML file.
 // It includes webhook settings, scan intervals, network options, and more.
 type Config struct {
-	WebhookURL          string            `yaml:"webhook_url"`
-	Token               string            `yaml:"nextpki_token,omitempty"`
-	ScanIntervalSeconds int               `yaml:"scan_interval_seconds"`
-	ScanThrottleDelayMs int               `yaml:"scan_throttle_delay_ms"`
-	EnableIPv6Discovery bool              `yaml:"enable_ipv6_discovery"`
-	EnableIPv4Discovery bool              `yaml:"enable_ipv4_discovery"`
-	Ports               []int             `yaml:"ports"`
-	IncludeList         []IncludeEntry    `yaml:"include_list"`
-	ExcludeList         []string          `yaml:"exclude_list"`
-	ExcludeCerts        []ExcludeCertRule `yaml:"exclude_certs"`
-	Debug               bool              `yaml:"debug"`
-	MachineID           string            `yaml:"machine_id,omitempty"`
-	ConcurrencyLimit    int               `yaml:"concurrency_limit"`
-	DialTimeoutMs       int               `yaml:"dial_timeout_ms"`
-	ICMPTimeoutMs       int               `yaml:"icmp_timeout_ms"`
-	HTTPTimeoutMs       int               `yaml:"http_timeout_ms"`
-	WebhookTimeoutMs    int               `yaml:"webhook_timeout_ms"`
-	EnableIPv6PingSweep bool              `yaml:"enable_ipv6_ping_sweep"`
-	EnableIPv6NDPSweep  bool              `yaml:"enable_ipv6_ndp_sweep"`
+	WebhookURL            string            `yaml:"webhook_url"`
+	Token                 string            `yaml:"nextpki_token,omitempty"`
+	ScanIntervalSeconds   int               `yaml:"scan_interval_seconds"`
+	ScanThrottleDelayMs   int               `yaml:"scan_throttle_delay_ms"`
+	EnableIPv6Discovery   bool              `yaml:"enable_ipv6_discovery"`
+	EnableIPv4Discovery   bool              `yaml:"enable_ipv4_discovery"`
+	Ports                 []int             `yaml:"ports"`
+	IncludeList           []IncludeEntry    `yaml:"include_list"`
+	ExcludeList           []string          `yaml:"exclude_list"`
+	ExcludeCerts          []ExcludeCertRule `yaml:"exclude_certs"`
+	Debug                 bool              `yaml:"debug"`
+	MachineID             string            `yaml:"machine_id,omitempty"`
+	ConcurrencyLimit      int               `yaml:"concurrency_limit"`
+	DialTimeoutMs         int               `yaml:"dial_timeout_ms"`
+	ICMPTimeoutMs         int               `yaml:"icmp_timeout_ms"`
+	HTTPTimeoutMs         int               `yaml:"http_timeout_ms"`
+	WebhookTimeoutMs      int               `yaml:"webhook_timeout_ms"`
+	EnableIPv6PingSweep   bool              `yaml:"enable_ipv6_ping_sweep"`
+	EnableIPv6NDPSweep    bool              `yaml:"enable_ipv6_ndp_sweep"`
+	EnumerateTLSVersions  bool              `yaml:"enumerate_tls_versions"`
+	EnumerateCipherSuites bool              `yaml:"enumerate_cipher_suites"`
+	DetectKeyExchange     bool              `yaml:"detect_key_exchange"`
+	HandshakeProfiles     []string          `yaml:"handshake_profiles"`
+	MultiSNI              bool              `yaml:"multi_sni"`
+	TrustedRoots          []string          `yaml:"trusted_roots"`
+	TrustedIntermediates  []string          `yaml:"trusted_intermediates"`
+	FetchIntermediates    bool              `yaml:"fetch_intermediates"`
+	AIACacheDir           string            `yaml:"aia_cache_dir,omitempty"`
+	CheckRevocation       bool              `yaml:"check_revocation"`
+	CRLCacheDir           string            `yaml:"crl_cache_dir,omitempty"`
 }
 
 const (
@@ -96,6 +155,18 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	for _, entry := range cfg.IncludeList {
+		if len(entry.Script) == 0 {
+			continue
+		}
+		if entry.Protocol != "custom" {
+			return nil, fmt.Errorf("include_list entry %q: script requires protocol \"custom\"", entry.Target)
+		}
+		if err := ValidateScript(entry.Script); err != nil {
+			return nil, fmt.Errorf("include_list entry %q: %w", entry.Target, err)
+		}
+	}
+
 	if cfg.DialTimeoutMs <= 0 {
 		cfg.DialTimeoutMs = DefaultDialTimeoutMs
 	}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []ScriptStep
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"send expect tls", []ScriptStep{{Send: "STARTSSL\r\n"}, {Expect: `^\+OK`}, {TLS: true}}, ""},
+		{"without tls step", []ScriptStep{{Expect: "^ready"}}, ""},
+		{"invalid regex", []ScriptStep{{Expect: "(unclosed"}}, "step 1: invalid expect regex"},
+		{"tls not last", []ScriptStep{{TLS: true}, {Send: "x"}}, "step 1: tls must be the last step"},
+		{"no action", []ScriptStep{{Send: "x"}, {}}, "step 2: exactly one of send, expect or tls"},
+		{"two actions", []ScriptStep{{Send: "x", Expect: "y"}}, "step 1: exactly one of send, expect or tls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScript(tt.steps)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeConfig writes a YAML configuration to a temporary file and returns its path.
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "custom protocol with script",
+			yaml: `
+include_list:
+  - target: "gw.example.net:7000"
+    protocol: custom
+    script:
+      - send: "STARTSSL\r\n"
+      - expect: "^\\+GO"
+      - tls: true
+`,
+		},
+		{
+			name: "script without protocol",
+			yaml: `
+include_list:
+  - target: "gw.example.net:7000"
+    script:
+      - send: "STARTSSL\r\n"
+`,
+			wantErr: `include_list entry "gw.example.net:7000": script requires protocol "custom"`,
+		},
+		{
+			name: "script with another protocol",
+			yaml: `
+include_list:
+  - target: "mail.example.net"
+    protocol: smtp
+    script:
+      - send: "EHLO x\r\n"
+`,
+			wantErr: `script requires protocol "custom"`,
+		},
+		{
+			name: "invalid script",
+			yaml: `
+include_list:
+  - target: "gw.example.net:7000"
+    protocol: custom
+    script:
+      - expect: "(unclosed"
+`,
+			wantErr: `include_list entry "gw.example.net:7000": step 1: invalid expect regex`,
+		},
+		{
+			name: "custom protocol without script",
+			yaml: `
+include_list:
+  - target: "gw.example.net:7000"
+    protocol: custom
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.IncludeList) != 1 {
+				t.Fatalf("got %d include_list entries", len(cfg.IncludeList))
+			}
+		})
+	}
+}
+
+func TestLoadConfigScriptSteps(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+include_list:
+  - target: "gw.example.net:7000"
+    protocol: custom
+    script:
+      - send: "STARTSSL\r\n"
+      - expect: "^\\+GO"
+      - tls: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScriptStep{{Send: "STARTSSL\r\n"}, {Expect: `^\+GO`}, {TLS: true}}
+	got := cfg.IncludeList[0].Script
+	if len(got) != len(want) {
+		t.Fatalf("got %d steps, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("step %d: got %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+	if cfg.DialTimeoutMs != DefaultDialTimeoutMs || cfg.FetchIntermediates {
+		t.Fatalf("defaults not applied: dial_timeout_ms %d, fetch_intermediates %v", cfg.DialTimeoutMs, cfg.FetchIntermediates)
+	}
+}
@@ -0,0 +1,223 @@
+// aia.go provides missing-intermediate repair for NextPKI.
+// When a chain does not verify against the trust store for lack of an issuer, the issuer of
+// the last certificate sent is downloaded from the AIA "CA Issuers" URL until the chain
+// reaches a trusted root. Downloaded certificates are cached on disk keyed by their Subject
+// Key Identifier, so each issuer is fetched only once.
+package scanner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+)
+
+// maxAIADepth bounds the number of issuers fetched for a single chain.
+const maxAIADepth = 4
+
+// maxAIAResponseSize bounds the size of a downloaded issuer certificate.
+const maxAIAResponseSize = 1 << 20
+
+// aiaRetryInterval is how long a failed CA Issuers URL is skipped, so unreachable URLs do not
+// delay every scan of the same chain by the HTTP timeout.
+const aiaRetryInterval = time.Hour
+
+// failedAIAURLs holds the time of the last failed download per CA Issuers URL.
+var failedAIAURLs sync.Map
+
+// aiaURLFailed reports whether a download from url failed within aiaRetryInterval.
+func aiaURLFailed(url string) bool {
+	failed, ok := failedAIAURLs.Load(url)
+	if ok && time.Since(failed.(time.Time)) >= aiaRetryInterval {
+		failedAIAURLs.CompareAndDelete(url, failed)
+		return false
+	}
+	return ok
+}
+
+// recordAIAFailure records a failed download from url and drops the entries whose retry
+// interval has passed, so URLs that are never seen again do not accumulate.
+func recordAIAFailure(url string) {
+	now := time.Now()
+	failedAIAURLs.Range(func(key, failed any) bool {
+		if now.Sub(failed.(time.Time)) >= aiaRetryInterval {
+			failedAIAURLs.CompareAndDelete(key, failed)
+		}
+		return true
+	})
+	failedAIAURLs.Store(url, now)
+}
+
+// cacheDir returns the configured cache directory, or the named directory below nextpki in
+// the user cache directory.
+func cacheDir(configured, name string) string {
+	if configured != "" {
+		return configured
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "nextpki", name)
+}
+
+// aiaCacheDir returns the directory of the issuer cache.
+func aiaCacheDir() string {
+	return cacheDir(shared.Config.AIACacheDir, "aia")
+}
+
+// aiaCachePath returns the cache file of the certificate with the given Subject Key Identifier.
+func aiaCachePath(ski []byte) string {
+	return filepath.Join(aiaCacheDir(), hex.EncodeToString(ski)+".der")
+}
+
+// loadCachedIssuer returns the cached certificate with the given Subject Key Identifier, or nil.
+func loadCachedIssuer(ski []byte) *x509.Certificate {
+	if len(ski) == 0 {
+		return nil
+	}
+	der, err := os.ReadFile(aiaCachePath(ski))
+	if err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// storeCachedIssuer writes a downloaded certificate to the cache. The file is renamed into
+// place so concurrent scans never read a partial certificate.
+func storeCachedIssuer(cert *x509.Certificate) {
+	if len(cert.SubjectKeyId) == 0 {
+		return
+	}
+	if err := os.MkdirAll(aiaCacheDir(), 0o755); err != nil {
+		logutil.ErrorLog("Failed to create AIA cache directory: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(aiaCacheDir(), "issuer-*.tmp")
+	if err != nil {
+		logutil.ErrorLog("Failed to write AIA cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(cert.Raw)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), aiaCachePath(cert.SubjectKeyId))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logutil.ErrorLog("Failed to write AIA cache: %v", err)
+	}
+}
+
+// downloadIssuer fetches a certificate from an AIA CA Issuers URL. DER and PEM responses are supported.
+func downloadIssuer(url string) (*x509.Certificate, error) {
+	client := &http.Client{Timeout: time.Duration(shared.Config.HTTPTimeoutMs) * time.Millisecond}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAIAResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil && block.Type == "CERTIFICATE" {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(bytes.TrimSpace(data))
+}
+
+// fetchIssuer returns the issuer of cert from the cache or its AIA CA Issuers URLs.
+// Returns nil if no certificate that signed cert could be obtained.
+func fetchIssuer(cert *x509.Certificate) *x509.Certificate {
+	if issuer := loadCachedIssuer(cert.AuthorityKeyId); issuer != nil && cert.CheckSignatureFrom(issuer) == nil {
+		return issuer
+	}
+	for _, url := range cert.IssuingCertificateURL {
+		if aiaURLFailed(url) {
+			continue
+		}
+		issuer, err := downloadIssuer(url)
+		if err != nil {
+			logutil.DebugLog("Failed to fetch issuer of %s from %s: %v", cert.Subject, url, err)
+			recordAIAFailure(url)
+			continue
+		}
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			logutil.DebugLog("Certificate from %s did not issue %s: %v", url, cert.Subject, err)
+			continue
+		}
+		storeCachedIssuer(issuer)
+		return issuer
+	}
+	return nil
+}
+
+// isSelfSigned reports whether cert is a self-signed (root) certificate.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// lacksTrustedPath reports whether the leaf of certs, together with the sent and fetched
+// intermediates and the trust store, fails to build a path to a trusted root. Other
+// verification errors (e.g. expiry or name mismatch) cannot be fixed by fetching issuers.
+func lacksTrustedPath(certs, fetched []*x509.Certificate) bool {
+	roots, trusted := trustStore()
+	intermediates := trusted.Clone()
+	for _, cert := range append(slices.Clone(certs[1:]), fetched...) {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	var authorityErr x509.UnknownAuthorityError
+	return errors.As(err, &authorityErr)
+}
+
+// completeChain follows the AIA CA Issuers URLs from the last certificate of a chain and
+// returns the intermediates the server did not send. Issuers are only fetched while the
+// chain does not verify against the trust store, so chains completed by a trusted root or
+// intermediate (e.g. a cross-signed intermediate) are left as sent. Roots are not returned.
+func completeChain(certs []*x509.Certificate) []*x509.Certificate {
+	if !shared.Config.FetchIntermediates || len(certs) == 0 || !lacksTrustedPath(certs, nil) {
+		return nil
+	}
+	var fetched []*x509.Certificate
+	current := certs[len(certs)-1]
+	for range maxAIADepth {
+		if isSelfSigned(current) {
+			break
+		}
+		issuer := fetchIssuer(current)
+		if issuer == nil || isSelfSigned(issuer) {
+			break
+		}
+		fetched = append(fetched, issuer)
+		if !lacksTrustedPath(certs, fetched) {
+			break
+		}
+		current = issuer
+	}
+	return fetched
+}
@@ -0,0 +1,174 @@
+package scanner
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nextpki/certscan/internal/config"
+)
+
+// caCertificate issues a CA certificate with the subject and key of sub, signed by parent,
+// with the given AIA CA Issuers URL. Signing the same CA by several parents cross-signs it.
+func caCertificate(t *testing.T, sub, parent *testIssuer, aiaURL string) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               sub.cert.Subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IssuingCertificateURL: []string{aiaURL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent.cert, &sub.key.PublicKey, parent.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// aiaServer serves DER certificates by path and counts the downloads per path.
+func aiaServer(t *testing.T, certs map[string]*x509.Certificate) (*httptest.Server, map[string]*atomic.Int32) {
+	hits := make(map[string]*atomic.Int32)
+	for path := range certs {
+		hits[path] = &atomic.Int32{}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert, ok := certs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		hits[r.URL.Path].Add(1)
+		w.Write(cert.Raw)
+	}))
+	t.Cleanup(server.Close)
+	return server, hits
+}
+
+func TestCompleteChainFetchesMissingIntermediate(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.FetchIntermediates = true
+	root := newTestIssuer(t, "AIA Root")
+	useTrustedRoots(t, cfg, root.cert)
+
+	certs := map[string]*x509.Certificate{"/root.der": root.cert}
+	server, hits := aiaServer(t, certs)
+	intermediate := root.subordinate(t, "AIA Intermediate", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	intermediate.cert = caCertificate(t, intermediate, root, server.URL+"/root.der")
+	certs["/intermediate.der"] = intermediate.cert
+	hits["/intermediate.der"] = &atomic.Int32{}
+	leaf := intermediate.issue(t, &x509.Certificate{IssuingCertificateURL: []string{server.URL + "/intermediate.der"}})
+
+	fetched := completeChain([]*x509.Certificate{leaf})
+	if len(fetched) != 1 || !fetched[0].Equal(intermediate.cert) {
+		t.Fatalf("got %d fetched certificates, want the intermediate", len(fetched))
+	}
+	// The chain reaches the trusted root once the intermediate is known
+	if n := hits["/root.der"].Load(); n != 0 {
+		t.Fatalf("root downloaded %d times", n)
+	}
+
+	// The complete chain needs no downloads
+	if fetched := completeChain([]*x509.Certificate{leaf, intermediate.cert}); len(fetched) != 0 {
+		t.Fatalf("fetched %d certificates for a complete chain", len(fetched))
+	}
+	if n := hits["/intermediate.der"].Load(); n != 1 {
+		t.Fatalf("intermediate downloaded %d times, want 1", n)
+	}
+
+	cfg.FetchIntermediates = false
+	if fetched := completeChain([]*x509.Certificate{leaf}); len(fetched) != 0 {
+		t.Fatal("fetched intermediates although fetch_intermediates is disabled")
+	}
+}
+
+func TestCompleteChainCrossSigned(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.FetchIntermediates = true
+	// The server sends the intermediate cross-signed by an old CA, whose issuer is fetchable;
+	// the trust store holds the self-signed version of the intermediate as a root
+	crossRoot := newTestIssuer(t, "Cross Root")
+	useTrustedRoots(t, cfg, crossRoot.cert)
+	ancient := newTestIssuer(t, "Ancient Root")
+	oldCA := ancient.subordinate(t, "Old CA", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	server, hits := aiaServer(t, map[string]*x509.Certificate{"/old-ca.der": oldCA.cert})
+	crossSigned := caCertificate(t, crossRoot, oldCA, server.URL+"/old-ca.der")
+	leaf := crossRoot.issue(t, &x509.Certificate{})
+
+	fetched := completeChain([]*x509.Certificate{leaf, crossSigned})
+	if len(fetched) != 0 || hits["/old-ca.der"].Load() != 0 {
+		t.Fatalf("fetched %d certificates for a chain that verifies via the cross-sign", len(fetched))
+	}
+	if validation := verifyChain(slices.Concat([]*x509.Certificate{leaf, crossSigned}, fetched), testHostname); !validation.Valid {
+		t.Fatalf("chain not valid: %+v", validation)
+	}
+}
+
+func TestFilterResultCertsFetched(t *testing.T) {
+	cfg := useTestConfig(t)
+	root := newTestIssuer(t, "Filter Root")
+	first := root.subordinate(t, "Filter Intermediate A", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	second := first.subordinate(t, "Filter Intermediate B", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	leaf := second.issue(t, &x509.Certificate{})
+	encode := func(certs ...*x509.Certificate) []string {
+		var out []string
+		for _, cert := range certs {
+			out = append(out, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		return out
+	}
+
+	// The leaf was sent by the server, both intermediates were fetched
+	cfg.ExcludeCerts = []config.ExcludeCertRule{{CN: "Filter Intermediate B"}}
+	results := []ScanResult{{Certificates: encode(leaf, second.cert, first.cert), ChainIncomplete: true, FetchedIntermediates: 2}}
+	filterResultCerts(results)
+	if !slices.Equal(results[0].Certificates, encode(leaf, first.cert)) || results[0].FetchedIntermediates != 1 {
+		t.Fatalf("got %d certificates with %d fetched", len(results[0].Certificates), results[0].FetchedIntermediates)
+	}
+
+	// Excluding a certificate sent by the server leaves the fetched count unchanged
+	cfg.ExcludeCerts = []config.ExcludeCertRule{{CN: testHostname}}
+	results = []ScanResult{{Certificates: encode(leaf, second.cert, first.cert), ChainIncomplete: true, FetchedIntermediates: 1}}
+	filterResultCerts(results)
+	if !slices.Equal(results[0].Certificates, encode(second.cert, first.cert)) || results[0].FetchedIntermediates != 1 {
+		t.Fatalf("got %d certificates with %d fetched", len(results[0].Certificates), results[0].FetchedIntermediates)
+	}
+}
+
+func TestAIAURLFailedExpires(t *testing.T) {
+	const stale, recent = "http://stale.scanner.test/ca.der", "http://recent.scanner.test/ca.der"
+	t.Cleanup(func() {
+		failedAIAURLs.Delete(stale)
+		failedAIAURLs.Delete(recent)
+	})
+	failedAIAURLs.Store(stale, time.Now().Add(-aiaRetryInterval-time.Minute))
+	recordAIAFailure(recent)
+
+	if _, ok := failedAIAURLs.Load(stale); ok {
+		t.Fatal("expired failure not pruned")
+	}
+	if !aiaURLFailed(recent) {
+		t.Fatal("recent failure not reported")
+	}
+	failedAIAURLs.Store(recent, time.Now().Add(-aiaRetryInterval))
+	if aiaURLFailed(recent) {
+		t.Fatal("failure reported after the retry interval")
+	}
+	if _, ok := failedAIAURLs.Load(recent); ok {
+		t.Fatal("expired failure not removed")
+	}
+}
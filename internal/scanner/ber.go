@@ -0,0 +1,128 @@
+// ber.go provides a minimal BER encoder and decoder for NextPKI.
+// It covers only the definite-length, single-byte-tag subset needed to speak
+// the LDAP StartTLS extended operation; it is not a general ASN.1 implementation.
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// BER universal tags used by the LDAP handler.
+const (
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+)
+
+// berMaxLength caps the length of a single element read from the network.
+const berMaxLength = 1 << 20
+
+// berElement is a decoded BER tag-length-value triple.
+type berElement struct {
+	Tag   byte
+	Value []byte
+}
+
+// berEncode encodes a single element with the given tag and value using definite-length form.
+func berEncode(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	n := len(value)
+	if n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var lenBytes []byte
+		for n > 0 {
+			lenBytes = append([]byte{byte(n)}, lenBytes...)
+			n >>= 8
+		}
+		out = append(out, 0x80|byte(len(lenBytes)))
+		out = append(out, lenBytes...)
+	}
+	return append(out, value...)
+}
+
+// berEncodeInt encodes a non-negative integer with the given tag (INTEGER or ENUMERATED).
+func berEncodeInt(tag byte, v int) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(v)}, value...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	// Keep the value positive in two's complement
+	if value[0]&0x80 != 0 {
+		value = append([]byte{0}, value...)
+	}
+	return berEncode(tag, value)
+}
+
+// berDecodeInt decodes the value of an INTEGER or ENUMERATED element.
+func berDecodeInt(value []byte) (int, error) {
+	if len(value) == 0 || len(value) > 4 {
+		return 0, fmt.Errorf("ber: invalid integer length %d", len(value))
+	}
+	v := 0
+	if value[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, b := range value {
+		v = v<<8 | int(b)
+	}
+	return v, nil
+}
+
+// berReadElement reads exactly one element from r.
+func berReadElement(r io.Reader) (berElement, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return berElement{}, err
+	}
+	tag, length := header[0], int(header[1])
+	if tag&0x1f == 0x1f {
+		return berElement{}, fmt.Errorf("ber: multi-byte tags are not supported")
+	}
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes == 0 {
+			return berElement{}, fmt.Errorf("ber: indefinite length is not supported")
+		}
+		if numBytes > 4 {
+			return berElement{}, fmt.Errorf("ber: length field too long (%d bytes)", numBytes)
+		}
+		lenBytes := make([]byte, numBytes)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return berElement{}, err
+		}
+		length = 0
+		for _, b := range lenBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > berMaxLength {
+		return berElement{}, fmt.Errorf("ber: element length %d exceeds limit", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return berElement{}, err
+	}
+	return berElement{Tag: tag, Value: value}, nil
+}
+
+// berParseElements decodes the concatenated elements inside a constructed value.
+func berParseElements(data []byte) ([]berElement, error) {
+	var elements []berElement
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		el, err := berReadElement(r)
+		if err != nil {
+			return nil, fmt.Errorf("ber: truncated element: %w", err)
+		}
+		elements = append(elements, el)
+	}
+	return elements, nil
+}
@@ -0,0 +1,161 @@
+// ciphers.go provides cipher suite enumeration and weak-cipher classification for NextPKI.
+// The suite selected by the server is removed from the offer and the handshake is repeated
+// until the server rejects the remaining suites, which yields the ordered list it accepts.
+package scanner
+
+import (
+	"crypto/tls"
+	"slices"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+	utls "github.com/refraction-networking/utls"
+)
+
+// CipherSuiteInfo describes a cipher suite accepted by the server.
+type CipherSuiteInfo struct {
+	Name       string   `json:"name"`                 // IANA name of the cipher suite
+	Version    string   `json:"version"`              // TLS version the suite was negotiated with
+	Weaknesses []string `json:"weaknesses,omitempty"` // Optional: null, export, des, rc4, 3des, cbc, non-pfs
+}
+
+// unimplementedCipherSuites are TLS 1.0-1.2 suites the TLS stack does not implement. They are
+// offered during enumeration so that servers restricted to them (e.g. DHE-only or TLS 1.0-era
+// configurations) are detected; the server's ServerHello is evaluated without completing the handshake.
+var unimplementedCipherSuites = []struct {
+	id   uint16
+	name string
+}{
+	{0x009e, "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256"},
+	{0x009f, "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384"},
+	{0xccaa, "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+	{0x0067, "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256"},
+	{0x006b, "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256"},
+	{0x0033, "TLS_DHE_RSA_WITH_AES_128_CBC_SHA"},
+	{0x0039, "TLS_DHE_RSA_WITH_AES_256_CBC_SHA"},
+	{0x0045, "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA"},
+	{0x0088, "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA"},
+	{0x0016, "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA"},
+	{0x0032, "TLS_DHE_DSS_WITH_AES_128_CBC_SHA"},
+	{0x0038, "TLS_DHE_DSS_WITH_AES_256_CBC_SHA"},
+	{0x0013, "TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA"},
+	{0xc024, "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384"},
+	{0xc028, "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384"},
+	{0xc008, "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA"},
+	{0x003d, "TLS_RSA_WITH_AES_256_CBC_SHA256"},
+	{0x0041, "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA"},
+	{0x0084, "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA"},
+	{0x0096, "TLS_RSA_WITH_SEED_CBC_SHA"},
+	{0x0007, "TLS_RSA_WITH_IDEA_CBC_SHA"},
+	{0x0004, "TLS_RSA_WITH_RC4_128_MD5"},
+	{0x0009, "TLS_RSA_WITH_DES_CBC_SHA"},
+	{0x0003, "TLS_RSA_EXPORT_WITH_RC4_40_MD5"},
+	{0x0008, "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA"},
+	{0x0014, "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA"},
+	{0x0002, "TLS_RSA_WITH_NULL_SHA"},
+	{0x003b, "TLS_RSA_WITH_NULL_SHA256"},
+	{0x0001, "TLS_RSA_WITH_NULL_MD5"},
+}
+
+// cipherSuiteName returns the IANA name of a cipher suite, including suites the TLS stack
+// does not implement.
+func cipherSuiteName(id uint16) string {
+	for _, suite := range unimplementedCipherSuites {
+		if suite.id == id {
+			return suite.name
+		}
+	}
+	return tls.CipherSuiteName(id)
+}
+
+// cipherSuiteWeaknesses classifies a cipher suite by its known weaknesses.
+// Returns an empty list for suites without findings.
+func cipherSuiteWeaknesses(id uint16) []string {
+	name := cipherSuiteName(id)
+	var weaknesses []string
+	if strings.Contains(name, "_NULL_") {
+		weaknesses = append(weaknesses, "null")
+	}
+	if strings.Contains(name, "_EXPORT_") {
+		weaknesses = append(weaknesses, "export")
+	}
+	if strings.Contains(name, "_DES_") || strings.Contains(name, "_DES40_") {
+		weaknesses = append(weaknesses, "des")
+	}
+	if strings.Contains(name, "_RC4_") {
+		weaknesses = append(weaknesses, "rc4")
+	}
+	if strings.Contains(name, "_3DES_") {
+		weaknesses = append(weaknesses, "3des")
+	}
+	if strings.Contains(name, "_CBC_") {
+		weaknesses = append(weaknesses, "cbc")
+	}
+	// Static RSA key exchange provides no forward secrecy
+	if strings.HasPrefix(name, "TLS_RSA_") {
+		weaknesses = append(weaknesses, "non-pfs")
+	}
+	return weaknesses
+}
+
+// enumerableCipherSuites splits all cipher suites known to the TLS stack, including
+// insecure ones, into TLS 1.0-1.2 suites and TLS 1.3 suites. The legacy suites are
+// completed with unimplementedCipherSuites.
+func enumerableCipherSuites() (legacy, tls13 []uint16) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.Contains(suite.SupportedVersions, tls.VersionTLS13) {
+			tls13 = append(tls13, suite.ID)
+		} else {
+			legacy = append(legacy, suite.ID)
+		}
+	}
+	for _, suite := range unimplementedCipherSuites {
+		legacy = append(legacy, suite.id)
+	}
+	return legacy, tls13
+}
+
+// cipherEnumerationEnabled reports whether cipher suite enumeration is enabled globally
+// or for the include_list entry matching the target.
+func cipherEnumerationEnabled(ip, hostname string, port int) bool {
+	if shared.Config.EnumerateCipherSuites {
+		return true
+	}
+	entry := includeEntryFor(ip, hostname, port)
+	return entry != nil && entry.EnumerateCipherSuites
+}
+
+// acceptedCipherSuites repeats the handshake with the given version range, removing the
+// suite selected by the server each time, and returns the accepted suites in server order.
+func acceptedCipherSuites(ip, hostname string, port int, minVersion, maxVersion uint16, offered []uint16, preamble connPreamble) []CipherSuiteInfo {
+	remaining := slices.Clone(offered)
+	var accepted []CipherSuiteInfo
+	for len(remaining) > 0 {
+		version, suite, err := probeHandshake(ip, hostname, port, probeSpec(hostname, minVersion, maxVersion, remaining), preamble)
+		if err != nil {
+			logutil.DebugLog("Cipher suite enumeration for %s:%d stopped: %v", ip, port, err)
+			break
+		}
+		idx := slices.Index(remaining, suite)
+		if idx < 0 {
+			// The server selected a suite that was not offered; stop to avoid looping
+			break
+		}
+		remaining = slices.Delete(remaining, idx, idx+1)
+		accepted = append(accepted, CipherSuiteInfo{
+			Name:       cipherSuiteName(suite),
+			Version:    tls.VersionName(version),
+			Weaknesses: cipherSuiteWeaknesses(suite),
+		})
+	}
+	return accepted
+}
+
+// enumerateCipherSuites returns all cipher suites the server accepts: TLS 1.3 suites first,
+// followed by the TLS 1.0-1.2 suites in the order the server selects them.
+func enumerateCipherSuites(ip, hostname string, port int, preamble connPreamble) []CipherSuiteInfo {
+	legacy, tls13 := enumerableCipherSuites()
+	suites := acceptedCipherSuites(ip, hostname, port, utls.VersionTLS13, utls.VersionTLS13, tls13, preamble)
+	return append(suites, acceptedCipherSuites(ip, hostname, port, utls.VersionTLS10, utls.VersionTLS12, legacy, preamble)...)
+}
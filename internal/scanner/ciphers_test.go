@@ -0,0 +1,116 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+)
+
+// fakeServerHelloServer answers a TLS 1.2 ClientHello with a bare ServerHello that selects the
+// first offered suite from accepted, or with a handshake_failure alert. It never completes the
+// handshake, like a server selecting a suite the TLS stack does not implement.
+func fakeServerHelloServer(t *testing.T, accepted []uint16) (string, int) {
+	alert := []byte{21, 3, 3, 0, 2, 2, 40}
+	return listenTCP(t, func(conn net.Conn) {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		record := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+		if _, err := io.ReadFull(conn, record); err != nil {
+			return
+		}
+		// handshake header (4), client_version (2), random (32), session id
+		hello := record[4:]
+		if len(hello) < 35 || binary.BigEndian.Uint16(hello[0:2]) != tls.VersionTLS12 {
+			conn.Write(alert)
+			return
+		}
+		rest := hello[35+int(hello[34]):]
+		suites := rest[2 : 2+binary.BigEndian.Uint16(rest[0:2])]
+		for i := 0; i+1 < len(suites); i += 2 {
+			suite := binary.BigEndian.Uint16(suites[i:])
+			if !slices.Contains(accepted, suite) {
+				continue
+			}
+			body := binary.BigEndian.AppendUint16(nil, tls.VersionTLS12)
+			body = append(body, make([]byte, 32)...) // random
+			body = append(body, 0)                   // session id
+			body = binary.BigEndian.AppendUint16(body, suite)
+			body = append(body, 0) // compression method
+			msg := append([]byte{handshakeTypeServerHello, 0, 0, byte(len(body))}, body...)
+			conn.Write(append([]byte{recordTypeHandshake, 3, 3, 0, byte(len(msg))}, msg...))
+			return
+		}
+		conn.Write(alert)
+	})
+}
+
+func TestEnumerateUnimplementedCipherSuites(t *testing.T) {
+	useTestConfig(t)
+	// TLS_DHE_RSA_WITH_AES_256_GCM_SHA384, TLS_DHE_RSA_WITH_AES_128_CBC_SHA, TLS_RSA_EXPORT_WITH_RC4_40_MD5
+	ip, port := fakeServerHelloServer(t, []uint16{0x009f, 0x0033, 0x0003})
+
+	if got := enumerateTLSVersions(ip, testHostname, port, nil); !slices.Equal(got, []string{"TLS 1.2"}) {
+		t.Fatalf("got versions %v, want [TLS 1.2]", got)
+	}
+
+	suites := enumerateCipherSuites(ip, testHostname, port, nil)
+	want := []CipherSuiteInfo{
+		{Name: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384", Version: "TLS 1.2"},
+		{Name: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA", Version: "TLS 1.2", Weaknesses: []string{"cbc"}},
+		{Name: "TLS_RSA_EXPORT_WITH_RC4_40_MD5", Version: "TLS 1.2", Weaknesses: []string{"export", "rc4", "non-pfs"}},
+	}
+	if len(suites) != len(want) {
+		t.Fatalf("got %+v, want %+v", suites, want)
+	}
+	for i := range want {
+		if suites[i].Name != want[i].Name || suites[i].Version != want[i].Version || !slices.Equal(suites[i].Weaknesses, want[i].Weaknesses) {
+			t.Fatalf("suite %d: got %+v, want %+v", i, suites[i], want[i])
+		}
+	}
+}
+
+func TestEnumerateCipherSuitesTLS10Only(t *testing.T) {
+	useTestConfig(t)
+	cfg := testTLSConfig(t)
+	cfg.MinVersion = tls.VersionTLS10
+	cfg.MaxVersion = tls.VersionTLS10
+	ip, port := listenTCP(t, func(conn net.Conn) { serveTLS(conn, cfg) })
+
+	suites := enumerateCipherSuites(ip, testHostname, port, nil)
+	if len(suites) == 0 {
+		t.Fatal("no cipher suites accepted")
+	}
+	for _, suite := range suites {
+		if suite.Version != "TLS 1.0" || !slices.Contains(suite.Weaknesses, "cbc") {
+			t.Fatalf("unexpected suite %+v", suite)
+		}
+	}
+	if !slices.ContainsFunc(suites, func(s CipherSuiteInfo) bool { return s.Name == "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA" }) {
+		t.Fatalf("TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA missing from %+v", suites)
+	}
+}
+
+func TestCipherSuiteWeaknesses(t *testing.T) {
+	tests := []struct {
+		id   uint16
+		want []string
+	}{
+		{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, nil},
+		{0xccaa, nil}, // TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+		{tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA, []string{"3des", "cbc", "non-pfs"}},
+		{tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA, []string{"rc4"}},
+		{0x0009, []string{"des", "cbc", "non-pfs"}}, // TLS_RSA_WITH_DES_CBC_SHA
+		{0x0014, []string{"export", "des", "cbc"}},  // TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA
+		{0x0002, []string{"null", "non-pfs"}},       // TLS_RSA_WITH_NULL_SHA
+	}
+	for _, tt := range tests {
+		if got := cipherSuiteWeaknesses(tt.id); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", cipherSuiteName(tt.id), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// clientauth.go provides client certificate request detection for NextPKI.
+// When the server sends a CertificateRequest, the acceptable CA distinguished names it
+// advertises are recorded and the handshake continues without a client certificate.
+// Servers that then reject the handshake have already sent their chain, so it is kept.
+package scanner
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// clientCertRequest records the CertificateRequest received during a handshake.
+type clientCertRequest struct {
+	requested     bool
+	acceptableCAs []string
+}
+
+// record stores the acceptable CAs of a CertificateRequest as distinguished name strings.
+func (r *clientCertRequest) record(acceptableCAs [][]byte) {
+	r.requested = true
+	for _, raw := range acceptableCAs {
+		var dn pkix.RDNSequence
+		if rest, err := asn1.Unmarshal(raw, &dn); err != nil || len(rest) > 0 {
+			continue
+		}
+		r.acceptableCAs = append(r.acceptableCAs, dn.String())
+	}
+}
+
+// getClientCertificate is the crypto/tls GetClientCertificate callback. It answers with an
+// empty certificate, which continues the handshake without client authentication.
+func (r *clientCertRequest) getClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.record(info.AcceptableCAs)
+	return &tls.Certificate{}, nil
+}
+
+// getUClientCertificate is the utls counterpart of getClientCertificate.
+func (r *clientCertRequest) getUClientCertificate(info *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+	r.record(info.AcceptableCAs)
+	return &utls.Certificate{}, nil
+}
+
+// rejectedAfterRequest reports whether a failed handshake was aborted by the server after it
+// requested a client certificate, with the server chain already received.
+func (r *clientCertRequest) rejectedAfterRequest(peerCertificates int) bool {
+	return r.requested && peerCertificates > 0
+}
@@ -0,0 +1,100 @@
+// custom.go provides the scripted "custom" protocol handler for NextPKI.
+// It runs the send/expect script of the matching include_list entry on a fresh
+// connection and then hands the connection to the utls handshake. Without a script,
+// the custom protocol performs a direct TLS handshake.
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/config"
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// scriptMaxLineLength caps a single line read while waiting for an expect pattern.
+const scriptMaxLineLength = 4096
+
+// readScriptLine reads a single line from conn one byte at a time, so that no bytes
+// belonging to the subsequent TLS handshake are consumed.
+func readScriptLine(conn net.Conn) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) < scriptMaxLineLength {
+		if _, err := conn.Read(buf); err != nil {
+			return "", err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// scriptPreamble validates the script and returns a connPreamble that executes it.
+// Send steps write their data verbatim; expect steps read lines until one matches.
+// The expect regexes are compiled once here rather than on every connection.
+func scriptPreamble(steps []config.ScriptStep) (connPreamble, error) {
+	if err := config.ValidateScript(steps); err != nil {
+		return nil, err
+	}
+	patterns := make([]*regexp.Regexp, len(steps))
+	for i, step := range steps {
+		if step.Expect == "" {
+			continue
+		}
+		re, err := regexp.Compile(step.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: invalid expect regex: %w", i+1, err)
+		}
+		patterns[i] = re
+	}
+	return func(conn net.Conn) error {
+		for i, step := range steps {
+			switch {
+			case step.Send != "":
+				if _, err := conn.Write([]byte(step.Send)); err != nil {
+					return fmt.Errorf("script step %d: send failed: %w", i+1, err)
+				}
+			case step.Expect != "":
+				for {
+					line, err := readScriptLine(conn)
+					if err != nil {
+						return fmt.Errorf("script step %d: expect %q failed: %w", i+1, step.Expect, err)
+					}
+					if patterns[i].MatchString(line) {
+						break
+					}
+					logutil.DebugLog("Script step %d: skipping line %q", i+1, line)
+				}
+			case step.TLS:
+				return nil
+			}
+		}
+		return nil
+	}, nil
+}
+
+// customProtocolHandler is a ProtocolHandler for the scripted custom protocol.
+// The script is taken from the include_list entry matching the target.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func customProtocolHandler(ip, hostname string, port int) []ScanResult {
+	var preamble connPreamble
+	if entry := includeEntryFor(ip, hostname, port); entry != nil && len(entry.Script) > 0 {
+		var err error
+		if preamble, err = scriptPreamble(entry.Script); err != nil {
+			logutil.ErrorLog("Invalid script for %s:%d: %v", ip, port, err)
+			return nil
+		}
+	}
+	results := collectTLSResults(ip, hostname, port, "custom", preamble)
+	if len(results) == 0 {
+		logutil.DebugLog("Custom protocol scan failed for %s:%d", ip, port)
+		return nil
+	}
+	logutil.DebugLog("Custom protocol scan successful for %s:%d", ip, port)
+	return results
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextpki/certscan/internal/config"
+)
+
+// fakeScriptServer waits for the command line "STARTSSL", answers with reply and, if the
+// reply announces "+GO", starts TLS.
+func fakeScriptServer(t *testing.T, reply string) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		line, err := bufio.NewReader(io.LimitReader(conn, int64(len("STARTSSL\r\n")))).ReadString('\n')
+		if err != nil || line != "STARTSSL\r\n" {
+			return
+		}
+		fmt.Fprint(conn, reply)
+		if strings.Contains(reply, "+GO") {
+			serveTLS(conn, cfg)
+		}
+	})
+}
+
+// startSSLScript sends STARTSSL and waits for the +GO line before the handshake.
+var startSSLScript = []config.ScriptStep{
+	{Send: "STARTSSL\r\n"},
+	{Expect: `^\+GO\b`},
+	{TLS: true},
+}
+
+func TestScriptPreamble(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		name    string
+		reply   string
+		wantErr error
+	}{
+		{"expect after skipped lines", "* banner v1.0\r\n* noise\r\n+GO ahead\r\n", nil},
+		{"LF line endings", "+GO\n", nil},
+		{"EOF before match", "-ERR not now\r\n", io.EOF},
+		{"timeout", "* still thinking\r\n", os.ErrDeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			var port int
+			if tt.wantErr == os.ErrDeadlineExceeded {
+				// Keep the connection open without sending the expected line
+				ip, port = listenTCP(t, func(conn net.Conn) {
+					fmt.Fprint(conn, tt.reply)
+					io.Copy(io.Discard, conn)
+				})
+			} else {
+				ip, port = fakeScriptServer(t, tt.reply)
+			}
+			preamble, err := scriptPreamble(startSSLScript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+
+			err = preamble(conn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !strings.Contains(err.Error(), "script step 2") {
+				t.Fatalf("error does not name the failing step: %v", err)
+			}
+		})
+	}
+}
+
+func TestScriptPreambleInvalid(t *testing.T) {
+	for _, steps := range [][]config.ScriptStep{
+		{{Expect: "(unclosed"}},
+		{{TLS: true}, {Send: "late\r\n"}},
+		{{Send: "a", Expect: "b"}},
+	} {
+		if _, err := scriptPreamble(steps); err == nil {
+			t.Fatalf("script %+v accepted", steps)
+		}
+	}
+}
+
+func TestCustomProtocolHandler(t *testing.T) {
+	cfg := useTestConfig(t)
+	ip, port := fakeScriptServer(t, "* hello\r\n+GO\r\n")
+	cfg.IncludeList = []config.IncludeEntry{{Target: testHostname, Protocol: "custom", Script: startSSLScript}}
+
+	results := customProtocolHandler(ip, testHostname, port)
+	if len(results) == 0 || results[0].TLSMode != tlsModeStartTLS || len(results[0].Certificates) != 1 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	// An entry built without LoadConfig may carry an invalid regex; it is reported, not run
+	cfg.IncludeList[0].Script = []config.ScriptStep{{Expect: "(unclosed"}, {TLS: true}}
+	if results := customProtocolHandler(ip, testHostname, port); len(results) != 0 {
+		t.Fatalf("got %d results for an invalid script", len(results))
+	}
+}
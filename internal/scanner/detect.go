@@ -0,0 +1,172 @@
+// detect.go provides automatic protocol detection for NextPKI.
+// Ports without a configured or well-known protocol are probed with a TLS ClientHello.
+// If the server sends a plaintext banner instead of a ServerHello, the banner is classified
+// and the matching protocol handler performs the actual (STARTTLS) scan.
+package scanner
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// detectTimeout bounds the ClientHello probe and the banner read of protocol detection.
+const detectTimeout = 5 * time.Second
+
+// detectBannerWait is how long to wait for the rest of a banner after the handshake failed.
+const detectBannerWait = 500 * time.Millisecond
+
+// detectGreetingWait is how long to listen for a server-first banner before the ClientHello
+// is sent. SMTP servers with pregreet checks (e.g. Postfix postscreen) reject clients that
+// talk before the greeting.
+const detectGreetingWait = time.Second
+
+// detectedProtocolTLS is reported for services that answered the probe with TLS.
+// They are scanned with the http1 handler, which performs a generic TLS handshake.
+const detectedProtocolTLS = "tls"
+
+// recordingConn records the bytes read from the connection, so a plaintext banner received
+// in place of a ServerHello can be classified after the handshake failed, or the server's
+// plaintext handshake messages can be inspected after the handshake completed.
+type recordingConn struct {
+	net.Conn
+	received bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Write(p[:n])
+	return n, err
+}
+
+// readBannerLine reads the rest of a banner until it contains a full line, waiting at most
+// detectBannerWait.
+func readBannerLine(conn net.Conn, banner []byte) []byte {
+	if len(banner) == 0 || bytes.Contains(banner, []byte("\n")) {
+		return banner
+	}
+	conn.SetReadDeadline(time.Now().Add(detectBannerWait))
+	buf := make([]byte, 512)
+	n, _ := conn.Read(buf)
+	return append(banner, buf[:n]...)
+}
+
+// detectProtocol listens for a server-first banner on ip:port and, if the server stays
+// silent, sends a TLS ClientHello and classifies the response.
+// Returns the detected protocol name, or an empty string if the service is unknown.
+func detectProtocol(ip, hostname string, port int) (string, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, configuredDialTimeout())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// Servers that greet first (SMTP, FTP, IMAP, POP3, ...) are classified without a ClientHello
+	conn.SetReadDeadline(time.Now().Add(detectGreetingWait))
+	buf := make([]byte, 512)
+	if n, _ := conn.Read(buf); n > 0 {
+		return classifyBanner(readBannerLine(conn, buf[:n])), nil
+	}
+	conn.SetDeadline(time.Now().Add(detectTimeout))
+
+	rc := &recordingConn{Conn: conn}
+	tlsConn := tls.Client(rc, &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err := tlsConn.Handshake(); err == nil {
+		switch tlsConn.ConnectionState().NegotiatedProtocol {
+		case "h2":
+			return "h2", nil
+		case "http/1.1":
+			return "http1", nil
+		}
+		return detectedProtocolTLS, nil
+	}
+
+	banner := rc.received.Bytes()
+	// A TLS record (alert or handshake) means the service speaks TLS, but rejected this ClientHello
+	if len(banner) > 0 && (banner[0] == 0x15 || banner[0] == 0x16) {
+		return detectedProtocolTLS, nil
+	}
+	// The handshake stops after the first record header, so read the rest of the banner line
+	return classifyBanner(readBannerLine(conn, banner)), nil
+}
+
+// classifyBanner maps a plaintext server greeting to a protocol name.
+// Returns an empty string if the banner is not recognised.
+func classifyBanner(banner []byte) string {
+	// MySQL initial handshake packet: 3-byte length, sequence id 0, protocol version 10
+	if len(banner) > 4 && banner[3] == 0 && banner[4] == 10 {
+		return "mysql"
+	}
+
+	text := string(banner)
+	if strings.Contains(text, "<stream:stream") {
+		if strings.Contains(text, "jabber:server") {
+			return "xmpp-server"
+		}
+		return "xmpp"
+	}
+	line, _, _ := strings.Cut(text, "\n")
+	line = strings.TrimSpace(line)
+	upper := strings.ToUpper(line)
+	switch {
+	case strings.HasPrefix(line, "220"):
+		return classifyGreeting220(upper[3:])
+	case strings.HasPrefix(upper, "* PREAUTH"):
+		// A pre-authenticated IMAP session cannot be upgraded with STARTTLS (RFC 3501, 6.2.1)
+		return ""
+	case strings.HasPrefix(upper, "* OK"):
+		return "imap"
+	case strings.HasPrefix(upper, "+OK"):
+		return "pop3"
+	case strings.HasPrefix(line, "200 "), strings.HasPrefix(line, "201 "):
+		return "nntp"
+	case strings.HasPrefix(upper, `"IMPLEMENTATION"`), strings.HasPrefix(upper, `"SIEVE"`):
+		return "sieve"
+	case strings.HasPrefix(line, ":"), strings.HasPrefix(upper, "NOTICE "):
+		return "irc"
+	}
+	return ""
+}
+
+// classifyGreeting220 tells SMTP, LMTP and FTP apart by the text of a 220 greeting (upper case,
+// without the code). Words containing a dot are hostnames and are skipped, so a greeting like
+// "220 sftp.example.com ESMTP Postfix" is not taken for FTP. SMTP and LMTP keywords win over
+// FTP server names (e.g. "ProFTPD", "vsFTPd"), and unknown greetings default to SMTP.
+func classifyGreeting220(text string) string {
+	var words []string
+	for _, word := range strings.Fields(strings.TrimPrefix(text, "-")) {
+		if !strings.Contains(word, ".") {
+			words = append(words, strings.Trim(word, "()[],;:-"))
+		}
+	}
+	for _, word := range words {
+		switch word {
+		case "LMTP":
+			return "lmtp"
+		case "SMTP", "ESMTP":
+			return "smtp"
+		}
+	}
+	for _, word := range words {
+		if strings.Contains(word, "FTP") || word == "FILEZILLA" {
+			return "ftp"
+		}
+	}
+	return "smtp"
+}
+
+// handlerProtocol returns the protocol handler used for a detected protocol.
+func handlerProtocol(detected string) string {
+	if detected == detectedProtocolTLS {
+		return "http1"
+	}
+	return detected
+}
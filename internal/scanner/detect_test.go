@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifyBanner(t *testing.T) {
+	tests := []struct {
+		banner string
+		want   string
+	}{
+		{"220 mail.scanner.test ESMTP Postfix\r\n", "smtp"},
+		{"220 sftp.example.com ESMTP Postfix\r\n", "smtp"},
+		{"220-ftp.example.com ESMTP Exim 4.96\r\n", "smtp"},
+		{"220 mx.example.com Microsoft ESMTP MAIL Service ready\r\n", "smtp"},
+		{"220 lmtp.example.com Dovecot ready. LMTP\r\n", "lmtp"},
+		{"220 ProFTPD Server (Debian) [::ffff:10.0.0.1]\r\n", "ftp"},
+		{"220 (vsFTPd 3.0.5)\r\n", "ftp"},
+		{"220 Microsoft FTP Service\r\n", "ftp"},
+		{"220-FileZilla Server 1.8.0\r\n", "ftp"},
+		{"220 ftp.example.com FTP server ready\r\n", "ftp"},
+		{"220 sftp.example.com\r\n", "smtp"},
+		{"* OK [CAPABILITY IMAP4rev1 STARTTLS] Dovecot ready.\r\n", "imap"},
+		{"* PREAUTH IMAP4rev1 server logged in as admin\r\n", ""},
+		{"+OK Dovecot ready.\r\n", "pop3"},
+		{"200 news.example.com InterNetNews ready\r\n", "nntp"},
+		{"\"IMPLEMENTATION\" \"Dovecot Pigeonhole\"\r\n", "sieve"},
+		{":irc.example.net NOTICE * :*** Looking up your hostname\r\n", "irc"},
+		{"<?xml version='1.0'?><stream:stream xmlns='jabber:client'>", "xmpp"},
+		{"<?xml version='1.0'?><stream:stream xmlns='jabber:server'>", "xmpp-server"},
+		{"\x4a\x00\x00\x00\x0a8.0.36\x00", "mysql"},
+		{"SSH-2.0-OpenSSH_9.6\r\n", ""},
+	}
+	for _, tt := range tests {
+		if got := classifyBanner([]byte(tt.banner)); got != tt.want {
+			t.Errorf("classifyBanner(%q) = %q, want %q", tt.banner, got, tt.want)
+		}
+	}
+}
+
+func TestDetectProtocolWaitsForGreeting(t *testing.T) {
+	useTestConfig(t)
+	// Like Postfix postscreen, the server sends a partial greeting and flags clients that
+	// talk before the greeting is complete
+	var pregreet atomic.Bool
+	ip, port := listenTCP(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220-mx.scanner.test ESMTP\r\n")
+		conn.SetReadDeadline(time.Now().Add(detectGreetingWait + time.Second))
+		if n, _ := conn.Read(make([]byte, 1)); n > 0 {
+			pregreet.Store(true)
+		}
+	})
+	detected, err := detectProtocol(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detected != "smtp" {
+		t.Fatalf("got %q, want smtp", detected)
+	}
+	if pregreet.Load() {
+		t.Fatal("client talked before the greeting")
+	}
+}
+
+func TestDetectProtocolTLS(t *testing.T) {
+	useTestConfig(t)
+	cfg := testTLSConfig(t)
+	cfg.NextProtos = []string{"http/1.1"}
+	ip, port := listenTCP(t, func(conn net.Conn) { serveTLS(conn, cfg) })
+	detected, err := detectProtocol(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detected != "http1" {
+		t.Fatalf("got %q, want http1", detected)
+	}
+}
+
+func TestScanAndSendReportsDetectedProtocol(t *testing.T) {
+	cfg := useTestConfig(t)
+	payloads := captureWebhook(t, cfg)
+	ip, port := fakeSMTPServer(t, testTLSConfig(t), 0)
+
+	ScanAndSendWithProtocol(ip, testHostname, []int{port}, "auto")
+	select {
+	case payload := <-payloads:
+		if len(payload.ScanResults) != 1 || payload.ScanResults[0].DetectedProtocol != "smtp" {
+			t.Fatalf("expected detected_protocol smtp, got %+v", payload.ScanResults)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook payload received")
+	}
+
+	// An explicit protocol is not reported as detected
+	ScanAndSendWithProtocol(ip, testHostname, []int{port}, "smtp")
+	select {
+	case payload := <-payloads:
+		if len(payload.ScanResults) != 1 || payload.ScanResults[0].DetectedProtocol != "" {
+			t.Fatalf("expected no detected_protocol, got %+v", payload.ScanResults)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook payload received")
+	}
+}
@@ -0,0 +1,92 @@
+// ftp.go provides the FTP AUTH TLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for FTP services supporting explicit TLS
+// (AUTH TLS / AUTH SSL, port 21) and implicit TLS (FTPS, port 990).
+package scanner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// ftpsPort is the well-known port for FTP over implicit TLS.
+const ftpsPort = 990
+
+// readFTPReply reads a complete, possibly multi-line FTP reply (RFC 959, section 4.2)
+// and returns its three-digit code and final line.
+func readFTPReply(s *lineSession) (string, string, error) {
+	line, err := s.readLine()
+	if err != nil {
+		return "", "", err
+	}
+	if len(line) < 4 {
+		return "", "", fmt.Errorf("malformed ftp reply: %q", line)
+	}
+	code := line[:3]
+	// A multi-line reply starts with "xyz-" and ends with a line starting with "xyz ";
+	// the lines in between may have any content
+	if line[3] == '-' {
+		lines, err := s.readReply(func(line string) bool {
+			return strings.HasPrefix(line, code+" ")
+		})
+		if err != nil {
+			return "", "", err
+		}
+		line = lines[len(lines)-1]
+	}
+	return code, line, nil
+}
+
+// scanFTPAuthTLS connects to an FTP server, requests AUTH TLS (falling back to AUTH SSL),
+// upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanFTPAuthTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	code, line, err := readFTPReply(s)
+	if err != nil {
+		return nil, fmt.Errorf("ftp greeting failed: %w", err)
+	}
+	if code != "220" {
+		return nil, fmt.Errorf("unexpected ftp greeting: %q", line)
+	}
+
+	upgraded := false
+	for _, mechanism := range []string{"TLS", "SSL"} {
+		if err := s.send("AUTH " + mechanism); err != nil {
+			return nil, fmt.Errorf("AUTH %s failed: %w", mechanism, err)
+		}
+		code, line, err = readFTPReply(s)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH %s read failed: %w", mechanism, err)
+		}
+		if code == "234" {
+			upgraded = true
+			break
+		}
+		logutil.DebugLog("FTP AUTH %s rejected by %s:%d: %s", mechanism, ip, port, line)
+	}
+	if !upgraded {
+		return nil, fmt.Errorf("AUTH TLS not supported on %s", ip)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// ftpProtocolHandler is a ProtocolHandler for FTP scanning.
+// Port 990 is scanned with a direct TLS handshake; all other ports use AUTH TLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func ftpProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == ftpsPort {
+		return defaultTLSHandler(ip, hostname, port, "ftp")
+	}
+	return startTLSResults("FTP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanFTPAuthTLS(ip, sni, port)
+	})
+}
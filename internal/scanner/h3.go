@@ -0,0 +1,225 @@
+// h3.go provides the HTTP/3 (QUIC) scan logic and protocol handler for NextPKI.
+// It implements certificate extraction over a QUIC handshake on UDP and discovers
+// additional HTTP/3 endpoints advertised via the Alt-Svc response header.
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// quicHandshakeTimeout bounds a complete QUIC handshake, including retransmissions.
+const quicHandshakeTimeout = 10 * time.Second
+
+// scanQUIC performs a QUIC handshake with ALPN "h3" over UDP and extracts the peer certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanQUIC(ip, hostname string, port int) (*ScanResult, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	ctx, cancel := context.WithTimeout(context.Background(), quicHandshakeTimeout)
+	defer cancel()
+
+	tlsConfig := &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true, // Allow all certs, including expired/invalid
+		NextProtos:         []string{"h3"},
+	}
+	quicConfig := &quic.Config{
+		// No response within the dial timeout means nothing is listening on this UDP port
+		HandshakeIdleTimeout: configuredDialTimeout(),
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("udp listen failed: %w", err)
+	}
+	defer udpConn.Close()
+	// The recording conn keeps the Initial packets for reading the key exchange group
+	recorder := &quicRecordingConn{PacketConn: udpConn}
+	transport := &quic.Transport{Conn: recorder}
+	defer transport.Close()
+
+	start := time.Now()
+	conn, err := transport.Dial(ctx, udpAddr, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, fmt.Errorf("quic handshake failed: %w", err)
+	}
+	latency := time.Since(start)
+	defer conn.CloseWithError(0, "")
+
+	state := conn.ConnectionState().TLS
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no cert returned")
+	}
+
+	var certs []string
+	for _, cert := range state.PeerCertificates {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	fetched, validation := checkChain(state.PeerCertificates, ip, hostname)
+	staple, scts := collectStapledData(state.PeerCertificates, fetched, state.OCSPResponse, state.SignedCertificateTimestamps)
+	for _, cert := range fetched {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	return &ScanResult{
+		IP:                   ip,
+		Port:                 port,
+		Hostname:             hostname,
+		SNI:                  sniValue(hostname),
+		HandshakeType:        "quic",
+		TLSMode:              tlsModeImplicit,
+		TLSVersion:           tls.VersionName(state.Version),
+		CipherSuite:          tls.CipherSuiteName(state.CipherSuite),
+		ALPN:                 state.NegotiatedProtocol,
+		KeyExchangeGroup:     recorder.negotiatedGroup(),
+		HandshakeLatencyMs:   latency.Milliseconds(),
+		OCSPStaple:           staple,
+		SCTs:                 scts,
+		Validation:           validation,
+		ChainIncomplete:      len(fetched) > 0,
+		FetchedIntermediates: len(fetched),
+		Certificates:         certs,
+		Timestamp:            time.Now().Unix(),
+	}, nil
+}
+
+// parseAltSvcH3Ports extracts the ports advertised for "h3" in an Alt-Svc header value (RFC 7838).
+// Only alternatives on the same host (empty host or matching hostname) are returned.
+func parseAltSvcH3Ports(header, hostname string) []int {
+	var ports []int
+	for _, entry := range strings.Split(header, ",") {
+		// Each entry is protocol-id="alt-authority" followed by optional ;-separated parameters
+		alternative := strings.TrimSpace(strings.SplitN(entry, ";", 2)[0])
+		proto, authority, ok := strings.Cut(alternative, "=")
+		if !ok || strings.TrimSpace(proto) != "h3" {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(strings.Trim(strings.TrimSpace(authority), `"`))
+		if err != nil {
+			continue
+		}
+		if host != "" && !strings.EqualFold(host, hostname) {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// discoverAltSvcH3Ports requests the root resource over HTTPS (TCP) and returns the UDP ports
+// the server advertises for HTTP/3 in its Alt-Svc header.
+func discoverAltSvcH3Ports(ip, hostname string, port int) ([]int, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: configuredDialTimeout()}
+	client := &http.Client{
+		Timeout: time.Duration(shared.Config.HTTPTimeoutMs) * time.Millisecond,
+		Transport: &http.Transport{
+			// Always connect to the scanned IP, regardless of what the hostname resolves to
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig: &tls.Config{
+				ServerName:         hostname,
+				InsecureSkipVerify: true,
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	url := "https://" + net.JoinHostPort(hostname, strconv.Itoa(port)) + "/"
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	var ports []int
+	for _, header := range resp.Header.Values("Alt-Svc") {
+		ports = append(ports, parseAltSvcH3Ports(header, hostname)...)
+	}
+	return ports, nil
+}
+
+// h3ProtocolHandler is a ProtocolHandler for HTTP/3 scanning.
+// It performs a QUIC handshake per server name (see sniNames) on the target port and on
+// every additional port advertised for h3 via Alt-Svc on the TCP endpoint of the same port.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func h3ProtocolHandler(ip, hostname string, port int) []ScanResult {
+	ports := []int{port}
+	altPorts, err := discoverAltSvcH3Ports(ip, hostname, port)
+	if err != nil {
+		logutil.DebugLog("Alt-Svc discovery failed for %s:%d: %v", ip, port, err)
+	}
+	for _, p := range altPorts {
+		if !containsPort(ports, p) {
+			logutil.DebugLog("Alt-Svc on %s:%d advertises h3 on UDP port %d", ip, port, p)
+			ports = append(ports, p)
+		}
+	}
+
+	// One handshake per port and server name; handshakes on a port returning the same chain
+	// are collapsed into one result, as in collectTLSResults
+	names := sniNames(ip, hostname, port)
+	var results []ScanResult
+	chains := make(map[string]int)
+	for _, p := range ports {
+		for i, sni := range names {
+			result, err := scanQUIC(ip, sni, p)
+			if err != nil {
+				logutil.DebugLog("QUIC scan (SNI %q) failed for %s:%d: %v", sni, ip, p, err)
+				// Without an answer for the hostname, the port is not probed with further names
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			logutil.DebugLog("QUIC scan (SNI %q) successful for %s:%d", sni, ip, p)
+			result.Hostname = hostname
+			chain := strconv.Itoa(p) + "/" + strings.Join(result.Certificates, ",")
+			if j, ok := chains[chain]; ok {
+				results[j].SNINames = append(results[j].SNINames, sni)
+				continue
+			}
+			chains[chain] = len(results)
+			result.SNINames = []string{sni}
+			results = append(results, *result)
+		}
+	}
+
+	// Check revocation on the complete chain, then filter certificates based on exclude_certs rules
+	annotateRevocation(results)
+	filterResultCerts(results)
+
+	return results
+}
+
+// containsPort returns true if ports contains port.
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// startHTTP3Server serves HTTP/3 on a loopback UDP port with a self-signed certificate.
+func startHTTP3Server(t *testing.T, cfg *tls.Config) (string, int) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(cfg),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestScanQUIC(t *testing.T) {
+	useTestConfig(t)
+	cfg := testTLSConfig(t)
+	cfg.CurvePreferences = []tls.CurveID{tls.X25519}
+	ip, port := startHTTP3Server(t, cfg)
+
+	result, err := scanQUIC(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Certificates) != 1 || result.HandshakeType != "quic" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if result.TLSVersion != "TLS 1.3" || result.ALPN != "h3" || !strings.HasPrefix(result.CipherSuite, "TLS_") {
+		t.Fatalf("handshake metadata missing: version %q, ALPN %q, suite %q", result.TLSVersion, result.ALPN, result.CipherSuite)
+	}
+	if result.KeyExchangeGroup != "X25519" {
+		t.Fatalf("got key exchange group %q, want X25519", result.KeyExchangeGroup)
+	}
+}
+
+func TestScanQUICHelloRetryRequest(t *testing.T) {
+	useTestConfig(t)
+	// The client sends no P-256 key share, so the server answers with a HelloRetryRequest
+	cfg := testTLSConfig(t)
+	cfg.CurvePreferences = []tls.CurveID{tls.CurveP256}
+	ip, port := startHTTP3Server(t, cfg)
+
+	result, err := scanQUIC(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyExchangeGroup != "CurveP256" {
+		t.Fatalf("got key exchange group %q, want CurveP256", result.KeyExchangeGroup)
+	}
+}
+
+func TestH3ProtocolHandlerMultiSNI(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.MultiSNI = true
+	// The server returns a default certificate unless the hostname is sent as SNI
+	named, fallback := testCertificate(t), testCertificate(t)
+	ip, port := startHTTP3Server(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == testHostname {
+				return &named, nil
+			}
+			return &fallback, nil
+		},
+	})
+
+	results := h3ProtocolHandler(ip, testHostname, port)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per distinct chain: %+v", len(results), results)
+	}
+	if results[0].SNI != testHostname || results[1].SNI != "" {
+		t.Fatalf("got SNIs %q and %q", results[0].SNI, results[1].SNI)
+	}
+	for _, result := range results {
+		if result.Hostname != testHostname || result.HandshakeType != "quic" || len(result.SNINames) == 0 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func TestQUICNegotiatedGroupRFC9001(t *testing.T) {
+	// Server Initial packet of RFC 9001, Appendix A.3, protected with the keys for the
+	// client DCID 0x8394c8f03e515708
+	packet, err := hex.DecodeString("" +
+		"cf000000010008f067a5502a4262b5004075c0d95a482cd0991cd25b0aac406a" +
+		"5816b6394100f37a1c69797554780bb38cc5a99f5ede4cf73c3ec2493a1839b3" +
+		"dbcba3f6ea46c5b7684df3548e7ddeb9c3bf9c73cc3f3bded74b562bfb19fb84" +
+		"022f8ef4cdd93795d77d06edbb7aaf2f58891850abbdca3d20398c276456cbc4" +
+		"2158407dd074ee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Minimal client Initial carrying the same DCID: no token, one byte of payload
+	clientInitial, _ := hex.DecodeString("c000000001088394c8f03e515708000001" + "00")
+
+	if group := quicNegotiatedGroup([][]byte{clientInitial}, [][]byte{packet}); group != "X25519" {
+		t.Fatalf("got %q, want X25519", group)
+	}
+	// Without the matching DCID the packet cannot be decrypted
+	other, _ := hex.DecodeString("c000000001080000000000000000000001" + "00")
+	if group := quicNegotiatedGroup([][]byte{other}, [][]byte{packet}); group != "" {
+		t.Fatalf("got %q for wrong keys", group)
+	}
+}
+
+func TestParseAltSvcH3Ports(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []int
+	}{
+		{"single entry", `h3=":8443"`, []int{8443}},
+		{"parameters", `h3=":443"; ma=86400; persist=1`, []int{443}},
+		{"several entries", `h3=":443"; ma=86400, h3-29=":443", h3=":8443", h2=":9443"`, []int{443, 8443}},
+		{"same host", `h3="scanner.test:4433"`, []int{4433}},
+		{"other host", `h3="cdn.example.net:443"`, nil},
+		{"clear", `clear`, nil},
+		{"invalid port", `h3=":0", h3=":70000", h3=":abc"`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAltSvcH3Ports(tt.header, testHostname); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverAltSvcH3Ports(t *testing.T) {
+	useTestConfig(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Alt-Svc", `h3=":8443"; ma=3600`)
+		w.Header().Add("Alt-Svc", `h3=":9443"`)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().(*net.TCPAddr)
+
+	ports, err := discoverAltSvcH3Ports(addr.IP.String(), testHostname, addr.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ports, []int{8443, 9443}) {
+		t.Fatalf("got %v, want [8443 9443] (port %s)", ports, strconv.Itoa(addr.Port))
+	}
+}
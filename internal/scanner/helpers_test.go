@@ -0,0 +1,224 @@
+package scanner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextpki/certscan/internal/config"
+	"github.com/nextpki/certscan/internal/shared"
+)
+
+// testHostname is the name the test certificates are issued for.
+const testHostname = "scanner.test"
+
+// useTestConfig installs a minimal configuration for the duration of the test.
+func useTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	previous := shared.Config
+	cfg := &config.Config{
+		DialTimeoutMs:    1000,
+		HTTPTimeoutMs:    1000,
+		WebhookTimeoutMs: 1000,
+		ConcurrencyLimit: 1,
+	}
+	shared.Config = cfg
+	t.Cleanup(func() { shared.Config = previous })
+	return cfg
+}
+
+// testCertificate returns a self-signed ECDSA certificate for testHostname.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: testHostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{testHostname},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testIssuer is a CA that issues certificates in tests.
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestIssuer creates a self-signed ECDSA CA with the given common name.
+func newTestIssuer(t *testing.T, name string) *testIssuer {
+	t.Helper()
+	return newTestCA(t, name, nil, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour))
+}
+
+// subordinate creates an intermediate CA signed by ca with the given validity period.
+func (ca *testIssuer) subordinate(t *testing.T, name string, notBefore, notAfter time.Time) *testIssuer {
+	t.Helper()
+	return newTestCA(t, name, ca, notBefore, notAfter)
+}
+
+// newTestCA creates an ECDSA CA certificate signed by parent, or self-signed if parent is nil.
+func newTestCA(t *testing.T, name string, parent *testIssuer, notBefore, notAfter time.Time) *testIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIssuer{cert: cert, key: key}
+}
+
+// issue signs a leaf certificate for testHostname. The template may set the serial number,
+// validity and revocation endpoints; the remaining fields are filled in.
+func (ca *testIssuer) issue(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(2)
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	template.Subject = pkix.Name{CommonName: testHostname}
+	template.DNSNames = []string{testHostname}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// useTrustedRoots adds certs to the trust store for the duration of the test. The AIA and
+// CRL caches are moved to temporary directories.
+func useTrustedRoots(t *testing.T, cfg *config.Config, certs ...*x509.Certificate) {
+	t.Helper()
+	var bundle []byte
+	for _, cert := range certs {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "roots.pem")
+	if err := os.WriteFile(path, bundle, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.TrustedRoots = []string{path}
+	cfg.AIACacheDir = t.TempDir()
+	cfg.CRLCacheDir = t.TempDir()
+	trustStoreOnce = sync.Once{}
+	t.Cleanup(func() { trustStoreOnce = sync.Once{} })
+}
+
+// testTLSConfig returns a server configuration presenting a fresh test certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	return &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+}
+
+// listenTCP serves every connection accepted on a loopback port with serve and returns the
+// listener's IP and port. The listener is closed when the test ends.
+func listenTCP(t *testing.T, serve func(conn net.Conn)) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				serve(conn)
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// tlsServer completes a server handshake on conn. Returns nil if the handshake failed.
+func tlsServer(conn net.Conn, cfg *tls.Config) *tls.Conn {
+	tlsConn := tls.Server(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil
+	}
+	return tlsConn
+}
+
+// serveTLS completes a server handshake on conn and waits for the client to close it.
+func serveTLS(conn net.Conn, cfg *tls.Config) {
+	if tlsConn := tlsServer(conn, cfg); tlsConn != nil {
+		tlsConn.Read(make([]byte, 1))
+	}
+}
+
+// captureWebhook points the webhook URL of cfg at a test server and returns the channel
+// receiving every posted payload.
+func captureWebhook(t *testing.T, cfg *config.Config) <-chan Payload {
+	t.Helper()
+	payloads := make(chan Payload, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+			payloads <- payload
+		}
+	}))
+	t.Cleanup(server.Close)
+	cfg.WebhookURL = server.URL
+	return payloads
+}
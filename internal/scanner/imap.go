@@ -0,0 +1,84 @@
+// imap.go provides the IMAP STARTTLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for IMAP services supporting STARTTLS (port 143)
+// and implicit TLS (IMAPS, port 993).
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// imapsPort is the well-known port for IMAP over implicit TLS.
+const imapsPort = 993
+
+// imapCommand sends a command with the given tag and reads lines until the tagged completion
+// line is received. Returns the untagged lines and the tagged status line.
+func imapCommand(s *lineSession, tag, command string) ([]string, string, error) {
+	lines, err := s.command(tag+" "+command, func(line string) bool {
+		return strings.HasPrefix(line, tag+" ")
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return lines[:len(lines)-1], lines[len(lines)-1], nil
+}
+
+// scanIMAPStartTLS connects to an IMAP server, checks the CAPABILITY list for STARTTLS,
+// upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanIMAPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	greeting, err := s.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("imap greeting failed: %w", err)
+	}
+	// STARTTLS is only valid in the not-authenticated state, so a PREAUTH greeting is rejected too
+	if !strings.HasPrefix(strings.ToUpper(greeting), "* OK") {
+		return nil, fmt.Errorf("unexpected imap greeting: %q", greeting)
+	}
+
+	lines, status, err := imapCommand(s, "a001", "CAPABILITY")
+	if err != nil {
+		return nil, fmt.Errorf("CAPABILITY read failed: %w", err)
+	}
+	if !strings.HasPrefix(strings.ToUpper(status), "A001 OK") {
+		return nil, fmt.Errorf("CAPABILITY failed: %q", status)
+	}
+
+	supportsStartTLS := false
+	for _, l := range lines {
+		upper := strings.ToUpper(l)
+		if strings.HasPrefix(upper, "* CAPABILITY") && strings.Contains(upper, "STARTTLS") {
+			supportsStartTLS = true
+			break
+		}
+	}
+	if !supportsStartTLS {
+		return nil, fmt.Errorf("STARTTLS not supported on %s", ip)
+	}
+
+	_, status, err = imapCommand(s, "a002", "STARTTLS")
+	if err != nil || !strings.HasPrefix(strings.ToUpper(status), "A002 OK") {
+		return nil, fmt.Errorf("STARTTLS failed: %q %v", status, err)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// imapProtocolHandler is a ProtocolHandler for IMAP scanning.
+// Port 993 is scanned with a direct TLS handshake; all other ports use STARTTLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func imapProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == imapsPort {
+		return defaultTLSHandler(ip, hostname, port, "imap")
+	}
+	return startTLSResults("IMAP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanIMAPStartTLS(ip, sni, port)
+	})
+}
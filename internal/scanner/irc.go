@@ -0,0 +1,141 @@
+// irc.go provides the IRC STARTTLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for IRC servers supporting the "tls" capability
+// and the STARTTLS command (port 6667) and for implicit TLS (port 6697).
+package scanner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/shared"
+)
+
+// ircsPort is the well-known port for IRC over implicit TLS.
+const ircsPort = 6697
+
+// IRC numerics used by the STARTTLS extension.
+const (
+	ircRplStartTLS      = "670"
+	ircErrStartTLS      = "691"
+	ircErrUnknownCmd    = "421"
+	ircErrNotRegistered = "451"
+)
+
+// ircCommand returns the command or numeric of an IRC message, skipping an optional :prefix.
+func ircCommand(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], ":") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// readIRCMessage reads the next IRC message, answering server PINGs transparently.
+func readIRCMessage(s *lineSession) (string, string, error) {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return "", "", err
+		}
+		command := ircCommand(line)
+		if command == "PING" {
+			token := strings.TrimSpace(line[strings.Index(strings.ToUpper(line), "PING")+4:])
+			s.send("PONG " + token)
+			continue
+		}
+		if command == "ERROR" {
+			return "", "", fmt.Errorf("irc server error: %s", line)
+		}
+		return line, command, nil
+	}
+}
+
+// ircListCapabilities sends CAP LS and collects the advertised capability names.
+// Returns ok=false if the server does not support capability negotiation.
+func ircListCapabilities(s *lineSession) ([]string, bool, error) {
+	if err := s.send("CAP LS 302"); err != nil {
+		return nil, false, err
+	}
+	var capabilities []string
+	for {
+		line, command, err := readIRCMessage(s)
+		if err != nil {
+			return nil, false, err
+		}
+		switch command {
+		case ircErrUnknownCmd, ircErrNotRegistered:
+			return nil, false, nil
+		case "CAP":
+			idx := strings.Index(strings.ToUpper(line), " LS ")
+			if idx < 0 {
+				continue
+			}
+			rest := line[idx+4:]
+			// A "*" before the list marks a continuation line (CAP 302 multiline)
+			more := strings.HasPrefix(rest, "* ")
+			if colon := strings.Index(rest, ":"); colon >= 0 {
+				for _, token := range strings.Fields(rest[colon+1:]) {
+					name, _, _ := strings.Cut(token, "=")
+					capabilities = append(capabilities, strings.ToLower(name))
+				}
+			}
+			if !more {
+				return capabilities, true, nil
+			}
+		}
+	}
+}
+
+// scanIRCStartTLS connects to an IRC server, checks the capability list for "tls",
+// issues STARTTLS, upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanIRCStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	capabilities, ok, err := ircListCapabilities(s)
+	if err != nil {
+		return nil, fmt.Errorf("CAP LS failed: %w", err)
+	}
+	// Servers without capability negotiation may still implement STARTTLS
+	if ok && !shared.Contains(capabilities, "tls") {
+		return nil, fmt.Errorf("STARTTLS not supported on %s", ip)
+	}
+
+	if err := s.send("STARTTLS"); err != nil {
+		return nil, fmt.Errorf("STARTTLS failed: %w", err)
+	}
+	for {
+		line, command, err := readIRCMessage(s)
+		if err != nil {
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+		if command == ircRplStartTLS {
+			break
+		}
+		if command == ircErrStartTLS || command == ircErrUnknownCmd || command == ircErrNotRegistered {
+			return nil, fmt.Errorf("STARTTLS failed: %s", line)
+		}
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// ircProtocolHandler is a ProtocolHandler for IRC scanning.
+// Port 6697 is scanned with a direct TLS handshake; all other ports use STARTTLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func ircProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == ircsPort {
+		return defaultTLSHandler(ip, hostname, port, "irc")
+	}
+	return startTLSResults("IRC", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanIRCStartTLS(ip, sni, port)
+	})
+}
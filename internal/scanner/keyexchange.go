@@ -0,0 +1,265 @@
+// keyexchange.go provides post-quantum and named-group key exchange detection for NextPKI.
+// A ClientHello offering the hybrid X25519MLKEM768 group alongside classic groups is sent,
+// and the group selected by the server, as well as whether it answered with a
+// HelloRetryRequest, is read from its plaintext handshake messages.
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+	utls "github.com/refraction-networking/utls"
+)
+
+// TLS record, handshake and extension types used when parsing the server's handshake.
+const (
+	recordTypeChangeCipherSpec = 20
+	recordTypeHandshake        = 22
+
+	handshakeTypeServerHello       = 2
+	handshakeTypeServerKeyExchange = 12
+
+	extensionSupportedVersions = 43
+	extensionKeyShare          = 51
+
+	ecCurveTypeNamedCurve = 3
+)
+
+// helloRetryRequestRandom is the fixed ServerHello.random value that marks a HelloRetryRequest (RFC 8446, 4.1.3).
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// keyExchangeGroups are the supported groups offered by the key exchange probe, in preference order.
+// Key shares are only sent for the first two, so servers preferring another group answer with a HelloRetryRequest.
+var keyExchangeGroups = []utls.CurveID{utls.X25519MLKEM768, utls.X25519, utls.CurveP256, utls.CurveP384}
+
+// hybridGroups maps the post-quantum hybrid groups recognised in results to their names.
+var hybridGroups = map[utls.CurveID]string{
+	utls.X25519MLKEM768:        "X25519MLKEM768",
+	utls.X25519Kyber768Draft00: "X25519Kyber768Draft00",
+	utls.CurveID(0x11eb):       "SecP256r1MLKEM768",
+	utls.CurveID(0x11ed):       "SecP384r1MLKEM1024",
+}
+
+// KeyExchangeInfo describes the key exchange negotiated by the key exchange probe.
+type KeyExchangeInfo struct {
+	Group             string `json:"group,omitempty"`               // Named group selected by the server (e.g. "X25519MLKEM768")
+	PostQuantum       bool   `json:"post_quantum"`                  // Whether the selected group is a post-quantum hybrid
+	HelloRetryRequest bool   `json:"hello_retry_request,omitempty"` // Whether the server answered with a HelloRetryRequest
+}
+
+// keyExchangeDetectionEnabled reports whether key exchange detection is enabled globally
+// or for the include_list entry matching the target.
+func keyExchangeDetectionEnabled(ip, hostname string, port int) bool {
+	if shared.Config.DetectKeyExchange {
+		return true
+	}
+	entry := includeEntryFor(ip, hostname, port)
+	return entry != nil && entry.DetectKeyExchange
+}
+
+// groupName returns the display name of a named group, including hybrids unknown to utls.
+func groupName(id utls.CurveID) string {
+	if name, ok := hybridGroups[id]; ok {
+		return name
+	}
+	name := id.String()
+	if strings.HasPrefix(name, "CurveID(") {
+		return fmt.Sprintf("0x%04x", uint16(id))
+	}
+	return name
+}
+
+// keyExchangeProbeSpec builds a ClientHello for TLS 1.2 and 1.3 that offers the hybrid
+// and classic groups of keyExchangeGroups.
+func keyExchangeProbeSpec(hostname string) *utls.ClientHelloSpec {
+	return &utls.ClientHelloSpec{
+		TLSVersMin:   utls.VersionTLS12,
+		TLSVersMax:   utls.VersionTLS13,
+		CipherSuites: append(append([]uint16{}, tls13CipherSuites...), legacyCipherSuites...),
+		Extensions: []utls.TLSExtension{
+			&utls.SNIExtension{ServerName: hostname},
+			&utls.SupportedCurvesExtension{Curves: keyExchangeGroups},
+			&utls.SupportedPointsExtension{SupportedPoints: []byte{0}}, // uncompressed
+			&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: probeSignatureAlgorithms},
+			&utls.SupportedVersionsExtension{Versions: []uint16{utls.VersionTLS13, utls.VersionTLS12}},
+			&utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: utls.X25519MLKEM768}, {Group: utls.X25519}}},
+			&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+		},
+	}
+}
+
+// serverHandshakeMessages extracts the plaintext handshake messages from the records sent by
+// the server. Parsing stops at the first record that is neither handshake nor ChangeCipherSpec,
+// which is where encryption starts.
+func serverHandshakeMessages(data []byte) [][]byte {
+	var stream []byte
+	for len(data) >= 5 {
+		recordType := data[0]
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		if recordType == recordTypeHandshake {
+			stream = append(stream, data[5:5+length]...)
+		} else if recordType != recordTypeChangeCipherSpec {
+			break
+		}
+		data = data[5+length:]
+	}
+	return splitHandshakeMessages(stream)
+}
+
+// splitHandshakeMessages splits a handshake stream into messages, each including its 4-byte header.
+func splitHandshakeMessages(stream []byte) [][]byte {
+	var messages [][]byte
+	for len(stream) >= 4 {
+		length := int(stream[1])<<16 | int(stream[2])<<8 | int(stream[3])
+		if len(stream) < 4+length {
+			break
+		}
+		messages = append(messages, stream[:4+length])
+		stream = stream[4+length:]
+	}
+	return messages
+}
+
+// serverHelloSelection returns the protocol version and cipher suite selected by the first
+// ServerHello in the records sent by the server, skipping HelloRetryRequests. The version is
+// taken from the supported_versions extension if present (TLS 1.3). This also works when the
+// handshake fails after the ServerHello, e.g. for suites the TLS stack does not implement.
+func serverHelloSelection(received []byte) (version, suite uint16, ok bool) {
+	for _, msg := range serverHandshakeMessages(received) {
+		if msg[0] != handshakeTypeServerHello {
+			continue
+		}
+		body := msg[4:]
+		// legacy_version (2), random (32), session id length (1)
+		if len(body) < 35 || bytes.Equal(body[2:34], helloRetryRequestRandom) {
+			continue
+		}
+		version = binary.BigEndian.Uint16(body[0:2])
+		rest := body[34:]
+		sessionIDLen := int(rest[0])
+		// session id, cipher suite (2), compression method (1)
+		if len(rest) < 1+sessionIDLen+3 {
+			return 0, 0, false
+		}
+		suite = binary.BigEndian.Uint16(rest[1+sessionIDLen:])
+		rest = rest[1+sessionIDLen+3:]
+		if len(rest) >= 2 {
+			extensions := rest[2:]
+			for len(extensions) >= 4 {
+				extType := binary.BigEndian.Uint16(extensions[0:2])
+				extLen := int(binary.BigEndian.Uint16(extensions[2:4]))
+				if len(extensions) < 4+extLen {
+					break
+				}
+				if extType == extensionSupportedVersions && extLen == 2 {
+					version = binary.BigEndian.Uint16(extensions[4:6])
+				}
+				extensions = extensions[4+extLen:]
+			}
+		}
+		return version, suite, true
+	}
+	return 0, 0, false
+}
+
+// parseServerHello returns the random and the key_share group of a ServerHello body.
+// The group is zero if the ServerHello carries no key_share extension (TLS 1.2).
+func parseServerHello(body []byte) ([]byte, utls.CurveID, error) {
+	// legacy_version (2), random (32), session id length (1)
+	if len(body) < 35 {
+		return nil, 0, fmt.Errorf("short ServerHello")
+	}
+	random := body[2:34]
+	rest := body[34:]
+	sessionIDLen := int(rest[0])
+	// session id, cipher suite (2), compression method (1)
+	if len(rest) < 1+sessionIDLen+3 {
+		return nil, 0, fmt.Errorf("short ServerHello")
+	}
+	rest = rest[1+sessionIDLen+3:]
+	if len(rest) < 2 {
+		return random, 0, nil
+	}
+	extensions := rest[2:]
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions[0:2])
+		extLen := int(binary.BigEndian.Uint16(extensions[2:4]))
+		if len(extensions) < 4+extLen {
+			break
+		}
+		// Both the ServerHello key share and the HelloRetryRequest selected_group start with the group
+		if extType == extensionKeyShare && extLen >= 2 {
+			return random, utls.CurveID(binary.BigEndian.Uint16(extensions[4:6])), nil
+		}
+		extensions = extensions[4+extLen:]
+	}
+	return random, 0, nil
+}
+
+// parseKeyExchange reads the selected group and the HelloRetryRequest flag from the raw bytes
+// the server sent during the handshake. TLS 1.3 groups are taken from the ServerHello key_share,
+// TLS 1.2 ECDHE groups from the ServerKeyExchange message.
+func parseKeyExchange(data []byte) (*KeyExchangeInfo, error) {
+	info := &KeyExchangeInfo{}
+	var group utls.CurveID
+	for _, msg := range serverHandshakeMessages(data) {
+		body := msg[4:]
+		switch msg[0] {
+		case handshakeTypeServerHello:
+			random, g, err := parseServerHello(body)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(random, helloRetryRequestRandom) {
+				info.HelloRetryRequest = true
+			}
+			if g != 0 {
+				group = g
+			}
+		case handshakeTypeServerKeyExchange:
+			if group == 0 && len(body) >= 3 && body[0] == ecCurveTypeNamedCurve {
+				group = utls.CurveID(binary.BigEndian.Uint16(body[1:3]))
+			}
+		}
+	}
+	if group != 0 {
+		info.Group = groupName(group)
+		_, info.PostQuantum = hybridGroups[group]
+	}
+	return info, nil
+}
+
+// negotiatedGroup returns the name of the key exchange group of a recorded handshake,
+// or an empty string if it cannot be determined (e.g. RSA key exchange).
+func negotiatedGroup(received []byte) string {
+	info, err := parseKeyExchange(received)
+	if err != nil {
+		return ""
+	}
+	return info.Group
+}
+
+// detectKeyExchange performs a handshake offering hybrid and classic groups and reports
+// which group the server selected.
+func detectKeyExchange(ip, hostname string, port int, preamble connPreamble) (*KeyExchangeInfo, error) {
+	_, received, err := recordedHandshake(ip, hostname, port, keyExchangeProbeSpec(hostname), preamble)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseKeyExchange(received)
+	if err != nil {
+		return nil, err
+	}
+	logutil.DebugLog("Key exchange for %s:%d: group %s (post-quantum: %v, HRR: %v)", ip, port, info.Group, info.PostQuantum, info.HelloRetryRequest)
+	return info, nil
+}
@@ -0,0 +1,118 @@
+// ldap.go provides the LDAP StartTLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for LDAP services supporting the StartTLS
+// extended operation (RFC 4511, port 389) and implicit TLS (LDAPS, port 636).
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ldapsPort is the well-known port for LDAP over implicit TLS.
+const ldapsPort = 636
+
+// ldapStartTLSOID is the requestName of the StartTLS extended operation (RFC 4511, section 4.14).
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// LDAP protocol op tags used by the StartTLS exchange.
+const (
+	ldapTagExtendedRequest     = 0x77 // [APPLICATION 23] constructed
+	ldapTagExtendedResponse    = 0x78 // [APPLICATION 24] constructed
+	ldapTagExtendedRequestName = 0x80 // [0] primitive
+)
+
+// ldapResultSuccess is the LDAP resultCode for success.
+const ldapResultSuccess = 0
+
+// ldapStartTLSRequest builds the LDAPMessage carrying a StartTLS ExtendedRequest.
+func ldapStartTLSRequest(messageID int) []byte {
+	op := berEncode(ldapTagExtendedRequest, berEncode(ldapTagExtendedRequestName, []byte(ldapStartTLSOID)))
+	msg := append(berEncodeInt(berTagInteger, messageID), op...)
+	return berEncode(berTagSequence, msg)
+}
+
+// parseLDAPExtendedResponse decodes an LDAPMessage containing an ExtendedResponse.
+// Returns the messageID, resultCode and diagnosticMessage.
+func parseLDAPExtendedResponse(msg berElement) (int, int, string, error) {
+	if msg.Tag != berTagSequence {
+		return 0, 0, "", fmt.Errorf("ldap: unexpected message tag 0x%02x", msg.Tag)
+	}
+	parts, err := berParseElements(msg.Value)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if len(parts) < 2 || parts[0].Tag != berTagInteger {
+		return 0, 0, "", fmt.Errorf("ldap: malformed LDAPMessage")
+	}
+	messageID, err := berDecodeInt(parts[0].Value)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if parts[1].Tag != ldapTagExtendedResponse {
+		return 0, 0, "", fmt.Errorf("ldap: unexpected protocolOp tag 0x%02x", parts[1].Tag)
+	}
+	fields, err := berParseElements(parts[1].Value)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	// ExtendedResponse starts with the LDAPResult components: resultCode, matchedDN, diagnosticMessage
+	if len(fields) < 3 || fields[0].Tag != berTagEnumerated || fields[2].Tag != berTagOctetString {
+		return 0, 0, "", fmt.Errorf("ldap: malformed ExtendedResponse")
+	}
+	resultCode, err := berDecodeInt(fields[0].Value)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return messageID, resultCode, string(fields[2].Value), nil
+}
+
+// scanLDAPStartTLS connects to an LDAP server, issues the StartTLS extended operation,
+// upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanLDAPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, configuredDialTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("tcp dial failed: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(starttlsIOTimeout))
+
+	const messageID = 1
+	if _, err := conn.Write(ldapStartTLSRequest(messageID)); err != nil {
+		return nil, fmt.Errorf("StartTLS request failed: %w", err)
+	}
+
+	msg, err := berReadElement(conn)
+	if err != nil {
+		return nil, fmt.Errorf("StartTLS response read failed: %w", err)
+	}
+	respID, resultCode, diagnostic, err := parseLDAPExtendedResponse(msg)
+	if err != nil {
+		return nil, err
+	}
+	// messageID 0 is an unsolicited notification such as Notice of Disconnection
+	if respID != messageID {
+		return nil, fmt.Errorf("StartTLS failed: unsolicited response (messageID %d, resultCode %d): %s", respID, resultCode, diagnostic)
+	}
+	if resultCode != ldapResultSuccess {
+		return nil, fmt.Errorf("StartTLS failed: resultCode %d: %s", resultCode, diagnostic)
+	}
+
+	// Upgrade connection
+	return upgradeAndCollect(conn, ip, hostname, port)
+}
+
+// ldapProtocolHandler is a ProtocolHandler for LDAP scanning.
+// Port 636 is scanned with a direct TLS handshake; all other ports use StartTLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func ldapProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == ldapsPort {
+		return defaultTLSHandler(ip, hostname, port, "ldap")
+	}
+	return startTLSResults("LDAP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanLDAPStartTLS(ip, sni, port)
+	})
+}
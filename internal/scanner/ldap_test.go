@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBERReadElementLengths(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		name    string
+		input   []byte
+		wantLen int
+		wantErr bool
+	}{
+		{"short form", []byte{berTagOctetString, 0x03, 'a', 'b', 'c'}, 3, false},
+		{"short form empty", []byte{berTagOctetString, 0x00}, 0, false},
+		{"short form maximum", append([]byte{berTagOctetString, 0x7f}, long[:127]...), 127, false},
+		{"long form one byte", append([]byte{berTagOctetString, 0x81, 0x80}, long[:128]...), 128, false},
+		{"long form two bytes", append([]byte{berTagOctetString, 0x82, 0x01, 0x2c}, long...), 300, false},
+		{"truncated header", []byte{berTagOctetString}, 0, true},
+		{"truncated value", []byte{berTagOctetString, 0x05, 'a', 'b'}, 0, true},
+		{"truncated long length", []byte{berTagOctetString, 0x82, 0x01}, 0, true},
+		{"truncated long value", append([]byte{berTagOctetString, 0x81, 0x80}, long[:10]...), 0, true},
+		{"indefinite length", []byte{berTagSequence, 0x80, 0x00, 0x00}, 0, true},
+		{"length field too long", []byte{berTagOctetString, 0x85, 1, 0, 0, 0, 0}, 0, true},
+		{"length over limit", []byte{berTagOctetString, 0x84, 0x7f, 0xff, 0xff, 0xff}, 0, true},
+		{"multi-byte tag", []byte{0x1f, 0x81, 0x00}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			el, err := berReadElement(bytes.NewReader(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got element with %d bytes", len(el.Value))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(el.Value) != tt.wantLen {
+				t.Fatalf("got length %d, want %d", len(el.Value), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestBEREncodeRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 70000} {
+		value := bytes.Repeat([]byte{0xab}, n)
+		el, err := berReadElement(bytes.NewReader(berEncode(berTagOctetString, value)))
+		if err != nil {
+			t.Fatalf("length %d: %v", n, err)
+		}
+		if el.Tag != berTagOctetString || !bytes.Equal(el.Value, value) {
+			t.Fatalf("length %d: round trip mismatch", n)
+		}
+	}
+	for _, v := range []int{0, 1, 127, 128, 255, 256, 65535} {
+		el, err := berReadElement(bytes.NewReader(berEncodeInt(berTagInteger, v)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := berDecodeInt(el.Value)
+		if err != nil || got != v {
+			t.Fatalf("integer %d: got %d, %v", v, got, err)
+		}
+	}
+}
+
+func TestBERParseElementsTruncated(t *testing.T) {
+	data := append(berEncode(berTagInteger, []byte{1}), berTagOctetString, 0x04, 'a')
+	if _, err := berParseElements(data); err == nil {
+		t.Fatal("expected error for truncated trailing element")
+	}
+}
+
+// ldapExtendedResponse builds an LDAPMessage with an ExtendedResponse.
+func ldapExtendedResponse(messageID, resultCode int, diagnostic string) []byte {
+	result := berEncodeInt(berTagEnumerated, resultCode)
+	result = append(result, berEncode(berTagOctetString, nil)...)
+	result = append(result, berEncode(berTagOctetString, []byte(diagnostic))...)
+	msg := append(berEncodeInt(berTagInteger, messageID), berEncode(ldapTagExtendedResponse, result)...)
+	return berEncode(berTagSequence, msg)
+}
+
+// fakeLDAPServer answers the StartTLS request with the given result and, on success, starts TLS.
+func fakeLDAPServer(t *testing.T, resultCode int, diagnostic string) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		msg, err := berReadElement(conn)
+		if err != nil {
+			return
+		}
+		parts, err := berParseElements(msg.Value)
+		if err != nil || len(parts) != 2 || parts[1].Tag != ldapTagExtendedRequest {
+			return
+		}
+		name, err := berParseElements(parts[1].Value)
+		if err != nil || len(name) != 1 || string(name[0].Value) != ldapStartTLSOID {
+			return
+		}
+		messageID, _ := berDecodeInt(parts[0].Value)
+		conn.Write(ldapExtendedResponse(messageID, resultCode, diagnostic))
+		if resultCode == ldapResultSuccess {
+			serveTLS(conn, cfg)
+		}
+	})
+}
+
+func TestScanLDAPStartTLS(t *testing.T) {
+	useTestConfig(t)
+	ip, port := fakeLDAPServer(t, ldapResultSuccess, "")
+	result, err := scanLDAPStartTLS(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLSMode != tlsModeStartTLS || len(result.Certificates) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestScanLDAPStartTLSRejected(t *testing.T) {
+	useTestConfig(t)
+	const protocolError = 2
+	ip, port := fakeLDAPServer(t, protocolError, "StartTLS not supported")
+	_, err := scanLDAPStartTLS(ip, testHostname, port)
+	if err == nil || !strings.Contains(err.Error(), "resultCode 2") || !strings.Contains(err.Error(), "StartTLS not supported") {
+		t.Fatalf("expected resultCode error, got %v", err)
+	}
+}
+
+func TestParseLDAPExtendedResponse(t *testing.T) {
+	msg, err := berReadElement(bytes.NewReader(ldapExtendedResponse(7, 52, "unavailable")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, code, diagnostic, err := parseLDAPExtendedResponse(msg)
+	if err != nil || id != 7 || code != 52 || diagnostic != "unavailable" {
+		t.Fatalf("got %d %d %q %v", id, code, diagnostic, err)
+	}
+
+	// A BindResponse instead of an ExtendedResponse is rejected
+	wrongOp := berEncode(berTagSequence, append(berEncodeInt(berTagInteger, 1), berEncode(0x61, nil)...))
+	msg, _ = berReadElement(bytes.NewReader(wrongOp))
+	if _, _, _, err := parseLDAPExtendedResponse(msg); err == nil {
+		t.Fatal("expected error for unexpected protocolOp")
+	}
+}
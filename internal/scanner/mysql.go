@@ -0,0 +1,134 @@
+// mysql.go provides the MySQL/MariaDB TLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for servers that negotiate TLS inside the
+// MySQL client/server handshake (initial handshake packet, SSLRequest, then TLS).
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// MySQL capability flags used during the handshake.
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+// mysqlMaxPacketSize caps the size of a packet read from the server.
+const mysqlMaxPacketSize = 1 << 16
+
+// readMySQLPacket reads a single MySQL protocol packet and returns its payload and sequence id.
+func readMySQLPacket(r io.Reader) ([]byte, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length > mysqlMaxPacketSize {
+		return nil, 0, fmt.Errorf("mysql packet too large (%d bytes)", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	return payload, header[3], nil
+}
+
+// parseMySQLHandshake parses the initial handshake packet (protocol version 10) and
+// returns the server version string and the server capability flags.
+func parseMySQLHandshake(payload []byte) (string, uint32, error) {
+	if len(payload) == 0 {
+		return "", 0, fmt.Errorf("empty mysql handshake")
+	}
+	if payload[0] == 0xff {
+		// ERR packet: 0xff, error code (2), message (optionally prefixed by '#' and SQL state)
+		msg := ""
+		if len(payload) > 3 {
+			msg = string(payload[3:])
+		}
+		return "", 0, fmt.Errorf("mysql server refused connection: %s", msg)
+	}
+	if payload[0] != 10 {
+		return "", 0, fmt.Errorf("unsupported mysql protocol version %d", payload[0])
+	}
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 0 {
+		return "", 0, fmt.Errorf("malformed mysql handshake: unterminated server version")
+	}
+	serverVersion := string(payload[1 : 1+end])
+
+	// Skip server version, connection id (4), auth-plugin-data-part-1 (8) and filler (1)
+	pos := 1 + end + 1 + 4 + 8 + 1
+	if len(payload) < pos+2 {
+		return "", 0, fmt.Errorf("malformed mysql handshake: missing capability flags")
+	}
+	capabilities := uint32(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	// The upper capability bytes follow the character set (1) and status flags (2)
+	if len(payload) >= pos+7 {
+		capabilities |= uint32(binary.LittleEndian.Uint16(payload[pos+5:pos+7])) << 16
+	}
+	return serverVersion, capabilities, nil
+}
+
+// mysqlSSLRequestPacket builds the SSLRequest packet (HandshakeResponse41 truncated after the
+// filler) with sequence id 1.
+func mysqlSSLRequestPacket() []byte {
+	payload := make([]byte, 32)
+	flags := uint32(mysqlClientLongPassword | mysqlClientProtocol41 | mysqlClientSSL | mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(payload[0:4], flags)
+	binary.LittleEndian.PutUint32(payload[4:8], 1<<24) // max packet size
+	payload[8] = 0x21                                  // utf8_general_ci
+	// payload[9:32] is reserved and stays zero
+
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 1}
+	return append(header, payload...)
+}
+
+// mysqlSSLRequest reads the initial handshake packet, verifies the CLIENT_SSL capability and
+// sends the SSLRequest packet. Returns the server version string announced in the greeting.
+func mysqlSSLRequest(conn net.Conn) (string, error) {
+	payload, _, err := readMySQLPacket(conn)
+	if err != nil {
+		return "", fmt.Errorf("mysql greeting failed: %w", err)
+	}
+	serverVersion, capabilities, err := parseMySQLHandshake(payload)
+	if err != nil {
+		return "", err
+	}
+	if capabilities&mysqlClientSSL == 0 {
+		return serverVersion, fmt.Errorf("CLIENT_SSL not supported by %s", serverVersion)
+	}
+	if _, err := conn.Write(mysqlSSLRequestPacket()); err != nil {
+		return serverVersion, fmt.Errorf("SSLRequest failed: %w", err)
+	}
+	return serverVersion, nil
+}
+
+// mysqlProtocolHandler is a ProtocolHandler for MySQL/MariaDB scanning.
+// The server version from the greeting is recorded on every result.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func mysqlProtocolHandler(ip, hostname string, port int) []ScanResult {
+	var serverVersion string
+	preamble := func(conn net.Conn) error {
+		version, err := mysqlSSLRequest(conn)
+		serverVersion = version
+		return err
+	}
+	results := collectTLSResults(ip, hostname, port, "mysql", preamble)
+	if len(results) == 0 {
+		logutil.DebugLog("MySQL TLS scan failed for %s:%d (server version: %q)", ip, port, serverVersion)
+		return nil
+	}
+	for i := range results {
+		results[i].ServerVersion = serverVersion
+	}
+	logutil.DebugLog("MySQL TLS scan successful for %s:%d (server version: %s)", ip, port, serverVersion)
+	return results
+}
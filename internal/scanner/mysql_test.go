@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mysqlHandshake builds an initial handshake packet payload (protocol version 10). Without
+// extended set, the payload ends after the lower capability flags, as sent by old servers.
+func mysqlHandshake(version string, capabilities uint32, extended bool) []byte {
+	payload := append([]byte{10}, version...)
+	payload = append(payload, 0)
+	payload = append(payload, 1, 0, 0, 0)            // connection id
+	payload = append(payload, []byte("abcdefgh")...) // auth-plugin-data-part-1
+	payload = append(payload, 0)                     // filler
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(capabilities))
+	if extended {
+		payload = append(payload, 0x21, 2, 0) // character set, status flags
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(capabilities>>16))
+		payload = append(payload, 21)                  // auth-plugin-data length
+		payload = append(payload, make([]byte, 10)...) // reserved
+		payload = append(payload, []byte("ijklmnopqrst\x00mysql_native_password\x00")...)
+	}
+	return payload
+}
+
+// mysqlPacket frames payload as a MySQL packet with the given sequence id.
+func mysqlPacket(payload []byte, seq byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+func TestParseMySQLHandshake(t *testing.T) {
+	const sslCaps = mysqlClientProtocol41 | mysqlClientSSL | mysqlClientSecureConnection | 0x00080000
+	tests := []struct {
+		name     string
+		payload  []byte
+		wantVer  string
+		wantCaps uint32
+		wantErr  string
+	}{
+		{"MySQL 8 with SSL", mysqlHandshake("8.0.36", sslCaps, true), "8.0.36", sslCaps, ""},
+		{"MariaDB without SSL", mysqlHandshake("5.5.5-10.11.6-MariaDB", mysqlClientProtocol41, true), "5.5.5-10.11.6-MariaDB", mysqlClientProtocol41, ""},
+		{"lower capability flags only", mysqlHandshake("5.0.96", sslCaps, false), "5.0.96", sslCaps & 0xffff, ""},
+		{"ERR packet", append([]byte{0xff, 0x6a, 0x04}, "Host '10.0.0.1' is not allowed to connect"...), "", 0, "is not allowed to connect"},
+		{"short ERR packet", []byte{0xff, 0x6a}, "", 0, "refused connection"},
+		{"protocol version 9", []byte{9, '3', '.', '2', 0}, "", 0, "unsupported mysql protocol version 9"},
+		{"unterminated version", []byte{10, '8', '.', '0'}, "", 0, "unterminated server version"},
+		{"truncated before capabilities", mysqlHandshake("8.0.36", sslCaps, false)[:20], "", 0, "missing capability flags"},
+		{"empty", nil, "", 0, "empty mysql handshake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, capabilities, err := parseMySQLHandshake(tt.payload)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVer || capabilities != tt.wantCaps {
+				t.Fatalf("got %q 0x%08x, want %q 0x%08x", version, capabilities, tt.wantVer, tt.wantCaps)
+			}
+		})
+	}
+}
+
+// fakeMySQLServer sends greeting, reads the SSLRequest packet and starts TLS.
+func fakeMySQLServer(t *testing.T, greeting []byte) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		conn.Write(greeting)
+		payload, seq, err := readMySQLPacket(conn)
+		if err != nil || seq != 1 || len(payload) != 32 {
+			return
+		}
+		if binary.LittleEndian.Uint32(payload[0:4])&mysqlClientSSL == 0 {
+			return
+		}
+		serveTLS(conn, cfg)
+	})
+}
+
+func TestMySQLSSLRequest(t *testing.T) {
+	useTestConfig(t)
+	withSSL := mysqlHandshake("8.0.36", mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection, true)
+	tests := []struct {
+		name     string
+		greeting []byte
+		close    bool // close the connection after the greeting
+		wantErr  string
+	}{
+		{"CLIENT_SSL", mysqlPacket(withSSL, 0), false, ""},
+		{"without CLIENT_SSL", mysqlPacket(mysqlHandshake("5.7.44", mysqlClientProtocol41, true), 0), false, "CLIENT_SSL not supported by 5.7.44"},
+		{"truncated packet", mysqlPacket(withSSL, 0)[:20], true, "mysql greeting failed"},
+		{"oversized packet", []byte{0xff, 0xff, 0xff, 0}, false, "packet too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port := listenTCP(t, func(conn net.Conn) {
+				conn.Write(tt.greeting)
+				if !tt.close {
+					io.Copy(io.Discard, conn)
+				}
+			})
+			conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_, err = mysqlSSLRequest(conn)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMySQLProtocolHandler(t *testing.T) {
+	useTestConfig(t)
+	greeting := mysqlPacket(mysqlHandshake("8.0.36", mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection, true), 0)
+	ip, port := fakeMySQLServer(t, greeting)
+	results := mysqlProtocolHandler(ip, testHostname, port)
+	if len(results) == 0 || results[0].ServerVersion != "8.0.36" || results[0].TLSMode != tlsModeStartTLS {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	// A server without CLIENT_SSL yields no result
+	ip, port = fakeMySQLServer(t, mysqlPacket(mysqlHandshake("5.7.44", mysqlClientProtocol41, true), 0))
+	if results := mysqlProtocolHandler(ip, testHostname, port); len(results) != 0 {
+		t.Fatalf("got %d results from a server without CLIENT_SSL", len(results))
+	}
+}
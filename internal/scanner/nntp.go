@@ -0,0 +1,67 @@
+// nntp.go provides the NNTP STARTTLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for NNTP services supporting STARTTLS
+// (RFC 4642, port 119) and implicit TLS (NNTPS, port 563).
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nntpsPort is the well-known port for NNTP over implicit TLS.
+const nntpsPort = 563
+
+// scanNNTPStartTLS connects to an NNTP server, checks CAPABILITIES for STARTTLS,
+// upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanNNTPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	greeting, err := s.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("nntp greeting failed: %w", err)
+	}
+	// 200: posting allowed, 201: posting prohibited
+	if !strings.HasPrefix(greeting, "200") && !strings.HasPrefix(greeting, "201") {
+		return nil, fmt.Errorf("unexpected nntp greeting: %q", greeting)
+	}
+
+	status, err := s.request("CAPABILITIES")
+	if err != nil {
+		return nil, fmt.Errorf("CAPABILITIES read failed: %w", err)
+	}
+	// Servers without CAPABILITIES support (RFC 977) may still accept STARTTLS
+	if strings.HasPrefix(status, "101") {
+		lines, err := s.readReply(isDotTerminator)
+		if err != nil {
+			return nil, fmt.Errorf("CAPABILITIES read failed: %w", err)
+		}
+		if !containsCapability(lines, "STARTTLS") {
+			return nil, fmt.Errorf("STARTTLS not supported on %s", ip)
+		}
+	}
+
+	reply, err := s.request("STARTTLS")
+	if err != nil || !strings.HasPrefix(reply, "382") {
+		return nil, fmt.Errorf("STARTTLS failed: %q %v", reply, err)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// nntpProtocolHandler is a ProtocolHandler for NNTP scanning.
+// Port 563 is scanned with a direct TLS handshake; all other ports use STARTTLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func nntpProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == nntpsPort {
+		return defaultTLSHandler(ip, hostname, port, "nntp")
+	}
+	return startTLSResults("NNTP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanNNTPStartTLS(ip, sni, port)
+	})
+}
@@ -0,0 +1,70 @@
+// pop3.go provides the POP3 STLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for POP3 services supporting STLS (port 110)
+// and implicit TLS (POP3S, port 995).
+package scanner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pop3sPort is the well-known port for POP3 over implicit TLS.
+const pop3sPort = 995
+
+// scanPOP3StartTLS connects to a POP3 server, checks the CAPA list for STLS,
+// upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanPOP3StartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	greeting, err := s.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("pop3 greeting failed: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return nil, fmt.Errorf("unexpected pop3 greeting: %q", greeting)
+	}
+
+	status, err := s.request("CAPA")
+	if err != nil {
+		return nil, fmt.Errorf("CAPA read failed: %w", err)
+	}
+	if !strings.HasPrefix(status, "+OK") {
+		return nil, fmt.Errorf("CAPA failed: %q", status)
+	}
+	// The capability list is a multi-line response terminated by a single "."
+	lines, err := s.readReply(isDotTerminator)
+	if err != nil {
+		return nil, fmt.Errorf("CAPA read failed: %w", err)
+	}
+	if !slices.ContainsFunc(lines, func(line string) bool {
+		return strings.EqualFold(strings.TrimSpace(line), "STLS")
+	}) {
+		return nil, fmt.Errorf("STLS not supported on %s", ip)
+	}
+
+	reply, err := s.request("STLS")
+	if err != nil || !strings.HasPrefix(reply, "+OK") {
+		return nil, fmt.Errorf("STLS failed: %q %v", reply, err)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// pop3ProtocolHandler is a ProtocolHandler for POP3 scanning.
+// Port 995 is scanned with a direct TLS handshake; all other ports use STLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func pop3ProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == pop3sPort {
+		return defaultTLSHandler(ip, hostname, port, "pop3")
+	}
+	return startTLSResults("POP3", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanPOP3StartTLS(ip, sni, port)
+	})
+}
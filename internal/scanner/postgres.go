@@ -0,0 +1,58 @@
+// postgres.go provides the PostgreSQL SSLRequest scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for PostgreSQL servers via the SSLRequest preamble
+// and via PostgreSQL 17 direct TLS negotiation (ALPN "postgresql").
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// postgresSSLRequestCode is the protocol version code identifying an SSLRequest message.
+const postgresSSLRequestCode = 80877103
+
+// postgresSSLRequest sends the 8-byte SSLRequest message and expects the single-byte 'S'
+// answer, after which the server expects a TLS ClientHello.
+func postgresSSLRequest(conn net.Conn) error {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint32(msg[0:4], 8)
+	binary.BigEndian.PutUint32(msg[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("SSLRequest failed: %w", err)
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("SSLRequest read failed: %w", err)
+	}
+	switch reply[0] {
+	case 'S':
+		return nil
+	case 'N':
+		return fmt.Errorf("server does not accept SSL connections")
+	default:
+		return fmt.Errorf("unexpected SSLRequest reply 0x%02x", reply[0])
+	}
+}
+
+// postgresProtocolHandler is a ProtocolHandler for PostgreSQL scanning.
+// It negotiates TLS with an SSLRequest first and falls back to direct TLS
+// (PostgreSQL 17 sslnegotiation=direct) if that yields no certificate.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func postgresProtocolHandler(ip, hostname string, port int) []ScanResult {
+	results := collectTLSResults(ip, hostname, port, "postgres", postgresSSLRequest)
+	if len(results) == 0 {
+		logutil.DebugLog("PostgreSQL SSLRequest scan failed for %s:%d, trying direct TLS", ip, port)
+		results = collectTLSResults(ip, hostname, port, "postgres", nil)
+	}
+	if len(results) == 0 {
+		logutil.DebugLog("PostgreSQL TLS scan failed for %s:%d", ip, port)
+		return nil
+	}
+	logutil.DebugLog("PostgreSQL TLS scan successful for %s:%d (mode: %s)", ip, port, results[0].TLSMode)
+	return results
+}
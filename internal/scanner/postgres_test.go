@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// bufferedConn reads through a bufio.Reader that may already hold peeked bytes.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// fakePostgresServer answers an SSLRequest with reply (no answer if reply is 0) and starts TLS
+// after 'S'. A connection starting with a TLS ClientHello is served directly if directTLS is set.
+func fakePostgresServer(t *testing.T, reply byte, directTLS bool) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		buffered := &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+		head, err := buffered.r.Peek(1)
+		if err != nil {
+			return
+		}
+		if head[0] == 0x16 {
+			if directTLS {
+				serveTLS(buffered, cfg)
+			}
+			return
+		}
+		msg := make([]byte, 8)
+		if _, err := io.ReadFull(buffered, msg); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(msg[0:4]) != 8 || binary.BigEndian.Uint32(msg[4:8]) != postgresSSLRequestCode {
+			return
+		}
+		if reply == 0 {
+			return
+		}
+		conn.Write([]byte{reply})
+		if reply == 'S' {
+			serveTLS(buffered, cfg)
+		}
+	})
+}
+
+func TestPostgresSSLRequest(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		name    string
+		reply   byte
+		wantErr string
+	}{
+		{"accepted", 'S', ""},
+		{"refused", 'N', "does not accept SSL"},
+		// A server too old for SSLRequest answers with an ErrorResponse
+		{"error response", 'E', "unexpected SSLRequest reply 0x45"},
+		{"connection closed", 0, "SSLRequest read failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port := fakePostgresServer(t, tt.reply, false)
+			conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			err = postgresSSLRequest(conn)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostgresProtocolHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     byte
+		directTLS bool
+		wantMode  string
+	}{
+		{"SSLRequest", 'S', false, tlsModeStartTLS},
+		{"direct TLS after refused SSLRequest", 'N', true, tlsModeImplicit},
+		{"no TLS", 'N', false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t)
+			ip, port := fakePostgresServer(t, tt.reply, tt.directTLS)
+			results := postgresProtocolHandler(ip, testHostname, port)
+			if tt.wantMode == "" {
+				if len(results) != 0 {
+					t.Fatalf("got %d results from a server without TLS", len(results))
+				}
+				return
+			}
+			if len(results) == 0 || results[0].TLSMode != tt.wantMode || len(results[0].Certificates) != 1 {
+				t.Fatalf("unexpected results: %+v", results)
+			}
+		})
+	}
+}
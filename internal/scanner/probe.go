@@ -0,0 +1,121 @@
+// probe.go provides post-handshake service probes for NextPKI.
+// After a successful TLS handshake, a minimal protocol hello is sent over the encrypted
+// connection to confirm which kind of service the certificate is protecting.
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// serviceProbeTimeout bounds a single post-handshake service probe.
+const serviceProbeTimeout = 3 * time.Second
+
+// serviceProbe sends a protocol hello over an established TLS connection and reports
+// whether the response confirms the expected service.
+type serviceProbe func(conn net.Conn) bool
+
+// serviceProbes maps protocol names to the probe confirming that service type.
+var serviceProbes = map[string]serviceProbe{
+	"mqtt":  probeMQTT,
+	"amqp":  probeAMQP,
+	"redis": probeRedis,
+	"kafka": probeKafka,
+}
+
+// probeService runs the service probe registered for proto, if any.
+// Returns proto if the service was confirmed, or an empty string otherwise.
+func probeService(conn net.Conn, proto string) string {
+	probe, ok := serviceProbes[proto]
+	if !ok {
+		return ""
+	}
+	conn.SetDeadline(time.Now().Add(serviceProbeTimeout))
+	if !probe(conn) {
+		return ""
+	}
+	return proto
+}
+
+// probeMQTT sends an MQTT 3.1.1 CONNECT packet and expects a CONNACK.
+// A CONNACK with a non-zero return code (e.g. not authorized) still confirms MQTT.
+func probeMQTT(conn net.Conn) bool {
+	clientID := "certscan"
+	variable := []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x3c} // protocol name, level 4, clean session, keepalive 60s
+	payload := append([]byte{0x00, byte(len(clientID))}, clientID...)
+	packet := append([]byte{0x10, byte(len(variable) + len(payload))}, variable...)
+	packet = append(packet, payload...)
+	if _, err := conn.Write(packet); err != nil {
+		return false
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	if reply[0] != 0x20 || reply[1] != 0x02 {
+		return false
+	}
+	conn.Write([]byte{0xe0, 0x00}) // DISCONNECT
+	return true
+}
+
+// probeAMQP sends the AMQP 0-9-1 protocol header and expects either a method frame
+// (Connection.Start) or the server's preferred protocol header (e.g. AMQP 1.0).
+func probeAMQP(conn net.Conn) bool {
+	if _, err := conn.Write([]byte{'A', 'M', 'Q', 'P', 0x00, 0x00, 0x09, 0x01}); err != nil {
+		return false
+	}
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	if bytes.HasPrefix(reply, []byte("AMQP")) {
+		return true
+	}
+	// Method frame on channel 0: type 1, channel 0x0000
+	return reply[0] == 0x01 && reply[1] == 0x00 && reply[2] == 0x00
+}
+
+// probeRedis sends an inline PING and expects a Redis simple string or error reply.
+// -NOAUTH and -ERR replies confirm Redis as well.
+func probeRedis(conn net.Conn) bool {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return false
+	}
+	reply := make([]byte, 64)
+	n, err := conn.Read(reply)
+	if err != nil || n == 0 {
+		return false
+	}
+	reply = reply[:n]
+	return bytes.HasPrefix(reply, []byte("+PONG")) ||
+		bytes.HasPrefix(reply, []byte("-NOAUTH")) ||
+		bytes.HasPrefix(reply, []byte("-ERR"))
+}
+
+// probeKafka sends an ApiVersions (v0) request and expects a response echoing the correlation id.
+func probeKafka(conn net.Conn) bool {
+	const correlationID = 0x63657274 // "cert"
+	clientID := "certscan"
+	body := make([]byte, 10, 10+len(clientID))
+	binary.BigEndian.PutUint16(body[0:2], 18) // api_key: ApiVersions
+	binary.BigEndian.PutUint16(body[2:4], 0)  // api_version
+	binary.BigEndian.PutUint32(body[4:8], correlationID)
+	binary.BigEndian.PutUint16(body[8:10], uint16(len(clientID)))
+	body = append(body, clientID...)
+	request := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	request = append(request, body...)
+	if _, err := conn.Write(request); err != nil {
+		return false
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(reply[4:8]) == correlationID
+}
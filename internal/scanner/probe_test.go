@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// brokerReplies read the protocol hello of each broker protocol from a TLS connection and
+// write a reply that confirms the service.
+var brokerReplies = map[string]func(conn net.Conn){
+	"mqtt": func(conn net.Conn) {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		io.ReadFull(conn, make([]byte, header[1]))
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x05}) // CONNACK, not authorized
+	},
+	"amqp": func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 8))
+		conn.Write([]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x0a, 0x00, 0x0a}) // Connection.Start
+	},
+	"redis": func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, len("PING\r\n")))
+		conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+	},
+	"kafka": func(conn net.Conn) {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		// Response header: length and the correlation id of the request
+		conn.Write(append([]byte{0, 0, 0, 4}, request[4:8]...))
+	},
+}
+
+// fakeTLSService completes a TLS handshake and hands the connection to reply.
+func fakeTLSService(t *testing.T, reply func(conn net.Conn)) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		tlsConn := tlsServer(conn, cfg)
+		if tlsConn == nil {
+			return
+		}
+		reply(tlsConn)
+		tlsConn.Read(make([]byte, 1))
+	})
+}
+
+func TestServiceProbes(t *testing.T) {
+	useTestConfig(t)
+	for proto, reply := range brokerReplies {
+		t.Run(proto, func(t *testing.T) {
+			ip, port := fakeTLSService(t, reply)
+			results := collectTLSResults(ip, testHostname, port, proto, nil)
+			if len(results) != 1 || results[0].ServiceType != proto {
+				t.Fatalf("expected service_type %q, got %+v", proto, results)
+			}
+		})
+	}
+}
+
+func TestServiceProbesMismatch(t *testing.T) {
+	useTestConfig(t)
+	// An HTTPS server answers every hello with an HTTP error
+	httpReply := func(conn net.Conn) {
+		conn.Read(make([]byte, 512))
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+	}
+	for proto := range brokerReplies {
+		t.Run(proto, func(t *testing.T) {
+			ip, port := fakeTLSService(t, httpReply)
+			results := collectTLSResults(ip, testHostname, port, proto, nil)
+			if len(results) != 1 || results[0].ServiceType != "" {
+				t.Fatalf("expected empty service_type, got %+v", results)
+			}
+		})
+	}
+}
+
+func TestServiceProbeUnknownProtocol(t *testing.T) {
+	useTestConfig(t)
+	ip, port := fakeTLSService(t, brokerReplies["redis"])
+	results := collectTLSResults(ip, testHostname, port, "http1", nil)
+	if len(results) != 1 || results[0].ServiceType != "" {
+		t.Fatalf("expected no service probe for http1, got %+v", results)
+	}
+}
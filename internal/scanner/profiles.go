@@ -0,0 +1,131 @@
+// profiles.go provides the signature-algorithm handshake profiles for NextPKI.
+// Servers can hold several certificates (e.g. ECDSA, RSA, Ed25519, ML-DSA) and select one
+// based on the signature algorithms offered by the client. Each profile offers a single
+// family, so every certificate of a multi-cert server is retrieved by its own handshake.
+package scanner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/nextpki/certscan/internal/shared"
+	utls "github.com/refraction-networking/utls"
+)
+
+// ML-DSA signature schemes for TLS 1.3 (draft-ietf-tls-mldsa), not yet known to utls.
+const (
+	mldsa44 utls.SignatureScheme = 0x0904
+	mldsa65 utls.SignatureScheme = 0x0905
+	mldsa87 utls.SignatureScheme = 0x0906
+)
+
+// ML-DSA public key algorithm identifiers (FIPS 204, RFC 9881).
+var (
+	oidMLDSA44 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 17}
+	oidMLDSA65 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 18}
+	oidMLDSA87 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 19}
+)
+
+// isMLDSAKey reports whether the certificate carries an ML-DSA public key. The algorithm
+// identifier is read from the SubjectPublicKeyInfo, as crypto/x509 does not know ML-DSA.
+func isMLDSAKey(cert *x509.Certificate) bool {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	oid := spki.Algorithm.Algorithm
+	return oid.Equal(oidMLDSA44) || oid.Equal(oidMLDSA65) || oid.Equal(oidMLDSA87)
+}
+
+// handshakeProfile describes the cipher suites and signature algorithms offered by one handshake.
+type handshakeProfile struct {
+	suites        []uint16
+	signatureAlgs []utls.SignatureScheme
+	tls13Only     bool // offer TLS 1.3 only, for schemes that do not exist in TLS 1.2
+	mldsaOnly     bool // report only chains with an ML-DSA leaf key, not the server's fallback certificate
+}
+
+// defaultHandshakeProfiles are used unless handshake_profiles is configured.
+var defaultHandshakeProfiles = []string{"ecdsa", "rsa"}
+
+// ecdsaSuites are offered by the "ecdsa" and "ed25519" profiles; TLS 1.2 uses the ECDSA suites for Ed25519 as well.
+var ecdsaSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+}
+
+// rsaSuites are offered by the "rsa" profile.
+var rsaSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_RSA_WITH_RC4_128_SHA,
+}
+
+// handshakeProfiles maps profile names (reported as handshake_type) to their ClientHello parameters.
+var handshakeProfiles = map[string]handshakeProfile{
+	"ecdsa": {
+		suites: ecdsaSuites,
+		signatureAlgs: []utls.SignatureScheme{
+			utls.ECDSAWithP256AndSHA256,
+			utls.ECDSAWithP384AndSHA384,
+			utls.ECDSAWithP521AndSHA512,
+		},
+	},
+	"rsa": {
+		suites: rsaSuites,
+		signatureAlgs: []utls.SignatureScheme{
+			utls.PKCS1WithSHA256,
+			utls.PKCS1WithSHA384,
+			utls.PKCS1WithSHA512,
+			utls.PSSWithSHA256,
+			utls.PSSWithSHA384,
+			utls.PSSWithSHA512,
+		},
+	},
+	"ed25519": {
+		suites:        ecdsaSuites,
+		signatureAlgs: []utls.SignatureScheme{utls.Ed25519},
+	},
+	// The certificate is recovered from the encrypted handshake (see tls13certs.go), as utls
+	// cannot complete a handshake with an ML-DSA key. Only the mandatory AES-128-GCM suite is offered.
+	"mldsa": {
+		suites:        []uint16{tls.TLS_AES_128_GCM_SHA256},
+		signatureAlgs: []utls.SignatureScheme{mldsa44, mldsa65, mldsa87},
+		tls13Only:     true,
+		mldsaOnly:     true,
+	},
+}
+
+// handshakeProfilesFor returns the profiles to use for a target: those of the matching
+// include_list entry, the global handshake_profiles, or the defaults.
+func handshakeProfilesFor(ip, hostname string, port int) []string {
+	if entry := includeEntryFor(ip, hostname, port); entry != nil && len(entry.HandshakeProfiles) > 0 {
+		return entry.HandshakeProfiles
+	}
+	if len(shared.Config.HandshakeProfiles) > 0 {
+		return shared.Config.HandshakeProfiles
+	}
+	return defaultHandshakeProfiles
+}
@@ -0,0 +1,346 @@
+// quicinitial.go provides key exchange group detection for QUIC handshakes for NextPKI.
+// The ServerHello of a QUIC handshake travels in Initial packets, which are protected with
+// keys derived from the client's Destination Connection ID (RFC 9001, 5.2). The datagrams of
+// the handshake are recorded, the server's Initial packets are decrypted and the key_share
+// group is read from the ServerHello, as crypto/tls does not report it before Go 1.25.
+package scanner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go/quicvarint"
+	utls "github.com/refraction-networking/utls"
+)
+
+// quicVersion1 is the QUIC version whose Initial packets can be decrypted (RFC 9000).
+const quicVersion1 = 0x00000001
+
+// maxRecordedDatagrams bounds the number of datagrams recorded per direction.
+const maxRecordedDatagrams = 32
+
+// QUIC frame types found in Initial packets (RFC 9000, 12.4).
+const (
+	quicFramePadding         = 0x00
+	quicFramePing            = 0x01
+	quicFrameAck             = 0x02
+	quicFrameAckECN          = 0x03
+	quicFrameCrypto          = 0x06
+	quicFrameConnectionClose = 0x1c
+)
+
+// quicInitialSalt is the salt for deriving QUIC version 1 Initial secrets (RFC 9001, 5.2).
+var quicInitialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+// quicRecordingConn records the first datagrams sent and received on a QUIC socket.
+type quicRecordingConn struct {
+	net.PacketConn
+	mu       sync.Mutex
+	sent     [][]byte
+	received [][]byte
+}
+
+// ReadFrom reads a datagram and records it.
+func (c *quicRecordingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if n > 0 {
+		c.mu.Lock()
+		if len(c.received) < maxRecordedDatagrams {
+			c.received = append(c.received, bytes.Clone(p[:n]))
+		}
+		c.mu.Unlock()
+	}
+	return n, addr, err
+}
+
+// WriteTo records a datagram and sends it.
+func (c *quicRecordingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	if len(c.sent) < maxRecordedDatagrams {
+		c.sent = append(c.sent, bytes.Clone(p))
+	}
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// SetReadBuffer lets quic-go size the receive buffer of the wrapped UDP socket. The conn
+// does not embed *net.UDPConn, as quic-go would then bypass ReadFrom.
+func (c *quicRecordingConn) SetReadBuffer(size int) error {
+	if conn, ok := c.PacketConn.(*net.UDPConn); ok {
+		return conn.SetReadBuffer(size)
+	}
+	return nil
+}
+
+// SetWriteBuffer lets quic-go size the send buffer of the wrapped UDP socket.
+func (c *quicRecordingConn) SetWriteBuffer(size int) error {
+	if conn, ok := c.PacketConn.(*net.UDPConn); ok {
+		return conn.SetWriteBuffer(size)
+	}
+	return nil
+}
+
+// negotiatedGroup returns the key exchange group selected by the server, or an empty string.
+func (c *quicRecordingConn) negotiatedGroup() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return quicNegotiatedGroup(c.sent, c.received)
+}
+
+// quicLongHeader is the unprotected part of a long header packet.
+type quicLongHeader struct {
+	packetType int    // Initial (0), 0-RTT (1), Handshake (2) or Retry (3)
+	version    uint32 // QUIC version
+	dcid       []byte // Destination Connection ID
+	pnOffset   int    // offset of the packet number
+	end        int    // offset after the packet
+}
+
+// parseQUICLongHeader parses the long header packet at the start of data.
+func parseQUICLongHeader(data []byte) (*quicLongHeader, error) {
+	if len(data) < 7 || data[0]&0x80 == 0 {
+		return nil, fmt.Errorf("not a long header packet")
+	}
+	h := &quicLongHeader{
+		packetType: int(data[0]>>4) & 0x3,
+		version:    binary.BigEndian.Uint32(data[1:5]),
+	}
+	if h.version != quicVersion1 {
+		return nil, fmt.Errorf("unsupported QUIC version 0x%08x", h.version)
+	}
+	if h.packetType == 3 {
+		return nil, fmt.Errorf("retry packet")
+	}
+	pos := 5
+	dcidLen := int(data[pos])
+	if len(data) < pos+1+dcidLen+1 {
+		return nil, fmt.Errorf("short header")
+	}
+	h.dcid = data[pos+1 : pos+1+dcidLen]
+	pos += 1 + dcidLen
+	pos += 1 + int(data[pos]) // Source Connection ID
+	if h.packetType == 0 {
+		if pos > len(data) {
+			return nil, fmt.Errorf("short header")
+		}
+		tokenLen, n, err := quicvarint.Parse(data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n + int(tokenLen)
+	}
+	if pos > len(data) {
+		return nil, fmt.Errorf("short header")
+	}
+	length, n, err := quicvarint.Parse(data[pos:])
+	if err != nil {
+		return nil, err
+	}
+	h.pnOffset = pos + n
+	h.end = h.pnOffset + int(length)
+	if h.end > len(data) {
+		return nil, fmt.Errorf("truncated packet")
+	}
+	return h, nil
+}
+
+// quicInitialKeys holds the packet protection of one direction of the Initial packets.
+type quicInitialKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// newQUICInitialKeys derives the Initial keys of the given direction ("client in" or
+// "server in") for the client's Destination Connection ID.
+func newQUICInitialKeys(dcid []byte, direction string) (*quicInitialKeys, error) {
+	initial, err := hkdf.Extract(sha256.New, dcid, quicInitialSalt)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hkdfExpandLabel(initial, direction, 32)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdfExpandLabel(secret, "quic key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hkdfExpandLabel(secret, "quic iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	hpKey, err := hkdfExpandLabel(secret, "quic hp", 16)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &quicInitialKeys{aead: aead, iv: iv, hp: hp}, nil
+}
+
+// open removes header and packet protection from the Initial packet described by h and
+// returns its payload (RFC 9001, 5.3 and 5.4).
+func (k *quicInitialKeys) open(data []byte, h *quicLongHeader) ([]byte, error) {
+	const sampleOffset = 4
+	if h.pnOffset+sampleOffset+aes.BlockSize > h.end {
+		return nil, fmt.Errorf("packet too short for header protection sample")
+	}
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, data[h.pnOffset+sampleOffset:h.pnOffset+sampleOffset+aes.BlockSize])
+
+	first := data[0] ^ (mask[0] & 0x0f)
+	pnLen := int(first&0x3) + 1
+	header := bytes.Clone(data[:h.pnOffset+pnLen])
+	header[0] = first
+	var pn uint64
+	for i := range pnLen {
+		header[h.pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[h.pnOffset+i])
+	}
+
+	nonce := bytes.Clone(k.iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return k.aead.Open(nil, nonce, data[h.pnOffset+pnLen:h.end], header)
+}
+
+// parseQUICCryptoFrames returns the CRYPTO frame data of a decrypted Initial payload by
+// stream offset. Parsing stops at the first frame type not allowed in Initial packets.
+func parseQUICCryptoFrames(payload []byte, frames map[uint64][]byte) {
+	next := func() (uint64, bool) {
+		v, n, err := quicvarint.Parse(payload)
+		if err != nil {
+			return 0, false
+		}
+		payload = payload[n:]
+		return v, true
+	}
+	for len(payload) > 0 {
+		frameType, ok := next()
+		if !ok {
+			return
+		}
+		switch frameType {
+		case quicFramePadding, quicFramePing:
+		case quicFrameAck, quicFrameAckECN:
+			// Largest Acknowledged, ACK Delay, ACK Range Count, First ACK Range
+			var values [4]uint64
+			for i := range values {
+				if values[i], ok = next(); !ok {
+					return
+				}
+			}
+			fields := 2 * values[2]
+			if frameType == quicFrameAckECN {
+				fields += 3
+			}
+			for range fields {
+				if _, ok = next(); !ok {
+					return
+				}
+			}
+		case quicFrameCrypto:
+			offset, ok := next()
+			if !ok {
+				return
+			}
+			length, ok := next()
+			if !ok || uint64(len(payload)) < length {
+				return
+			}
+			frames[offset] = payload[:length]
+			payload = payload[length:]
+		case quicFrameConnectionClose:
+			return
+		default: // not allowed in Initial packets
+			return
+		}
+	}
+}
+
+// quicNegotiatedGroup decrypts the server's Initial packets of a recorded QUIC handshake and
+// returns the key_share group of the last ServerHello, or an empty string.
+func quicNegotiatedGroup(sent, received [][]byte) string {
+	// The Initial keys are derived from the DCID of the client's Initial packets, which
+	// changes after a Retry
+	var keys []*quicInitialKeys
+	seen := map[string]bool{}
+	for _, datagram := range sent {
+		h, err := parseQUICLongHeader(datagram)
+		if err != nil || h.packetType != 0 || seen[string(h.dcid)] {
+			continue
+		}
+		seen[string(h.dcid)] = true
+		if k, err := newQUICInitialKeys(h.dcid, "server in"); err == nil {
+			keys = append(keys, k)
+		}
+	}
+
+	frames := map[uint64][]byte{}
+	for _, datagram := range received {
+		// A datagram may carry coalesced Initial and Handshake packets
+		for len(datagram) > 0 {
+			h, err := parseQUICLongHeader(datagram)
+			if err != nil {
+				break
+			}
+			if h.packetType == 0 {
+				for _, k := range keys {
+					if payload, err := k.open(datagram, h); err == nil {
+						parseQUICCryptoFrames(payload, frames)
+						break
+					}
+				}
+			}
+			datagram = datagram[h.end:]
+		}
+	}
+
+	// Reassemble the crypto stream from offset 0; retransmitted frames may overlap
+	var stream []byte
+	for extended := true; extended; {
+		extended = false
+		for offset, data := range frames {
+			end := offset + uint64(len(data))
+			if offset <= uint64(len(stream)) && end > uint64(len(stream)) {
+				stream = append(stream, data[uint64(len(stream))-offset:]...)
+				extended = true
+			}
+		}
+	}
+	var group utls.CurveID
+	for _, msg := range splitHandshakeMessages(stream) {
+		if msg[0] != handshakeTypeServerHello {
+			continue
+		}
+		if _, g, err := parseServerHello(msg[4:]); err == nil && g != 0 {
+			group = g
+		}
+	}
+	if group == 0 {
+		return ""
+	}
+	return groupName(group)
+}
@@ -0,0 +1,103 @@
+// rdp.go provides the RDP TLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for RDP services by negotiating TLS (PROTOCOL_SSL)
+// or CredSSP (PROTOCOL_HYBRID) with an X.224 Connection Request (MS-RDPBCGR 2.2.1.1).
+// Only the TLS handshake is performed; no authentication takes place.
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// RDP negotiation constants (MS-RDPBCGR 2.2.1.1.1 and 2.2.1.2).
+const (
+	rdpNegTypeRequest  = 0x01
+	rdpNegTypeResponse = 0x02
+	rdpNegTypeFailure  = 0x03
+
+	rdpProtocolSSL    = 0x00000001
+	rdpProtocolHybrid = 0x00000002
+)
+
+// x224TypeConnectionConfirm is the TPDU code of an X.224 Connection Confirm.
+const x224TypeConnectionConfirm = 0xd0
+
+// rdpConnectionRequest builds a TPKT-framed X.224 Connection Request carrying an
+// RDP Negotiation Request for PROTOCOL_SSL and PROTOCOL_HYBRID.
+func rdpConnectionRequest() []byte {
+	negReq := make([]byte, 8)
+	negReq[0] = rdpNegTypeRequest
+	binary.LittleEndian.PutUint16(negReq[2:4], 8)
+	binary.LittleEndian.PutUint32(negReq[4:8], rdpProtocolSSL|rdpProtocolHybrid)
+
+	// X.224 CR TPDU: length indicator, CR code, DST-REF, SRC-REF, class option
+	tpdu := []byte{0, 0xe0, 0, 0, 0, 0, 0}
+	tpdu = append(tpdu, negReq...)
+	tpdu[0] = byte(len(tpdu) - 1)
+
+	tpkt := make([]byte, 4, 4+len(tpdu))
+	tpkt[0] = 3 // TPKT version
+	binary.BigEndian.PutUint16(tpkt[2:4], uint16(4+len(tpdu)))
+	return append(tpkt, tpdu...)
+}
+
+// rdpNegotiateTLS sends the X.224 Connection Request and parses the Connection Confirm.
+// It succeeds if the server selected a TLS-based security protocol.
+func rdpNegotiateTLS(conn net.Conn) error {
+	if _, err := conn.Write(rdpConnectionRequest()); err != nil {
+		return fmt.Errorf("X.224 connection request failed: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("X.224 connection confirm read failed: %w", err)
+	}
+	if header[0] != 3 {
+		return fmt.Errorf("unexpected TPKT version %d", header[0])
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 4+7 || length > 512 {
+		return fmt.Errorf("invalid TPKT length %d", length)
+	}
+	tpdu := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, tpdu); err != nil {
+		return fmt.Errorf("X.224 connection confirm read failed: %w", err)
+	}
+	if tpdu[1]&0xf0 != x224TypeConnectionConfirm {
+		return fmt.Errorf("unexpected X.224 TPDU type 0x%02x", tpdu[1])
+	}
+
+	negData := tpdu[7:]
+	if len(negData) < 8 {
+		return fmt.Errorf("server only supports standard RDP security (no TLS)")
+	}
+	value := binary.LittleEndian.Uint32(negData[4:8])
+	switch negData[0] {
+	case rdpNegTypeResponse:
+		if value&(rdpProtocolSSL|rdpProtocolHybrid) == 0 {
+			return fmt.Errorf("server selected non-TLS protocol 0x%08x", value)
+		}
+		logutil.DebugLog("RDP server selected protocol 0x%08x", value)
+		return nil
+	case rdpNegTypeFailure:
+		return fmt.Errorf("RDP negotiation failed with code %d", value)
+	default:
+		return fmt.Errorf("unexpected RDP negotiation type 0x%02x", negData[0])
+	}
+}
+
+// rdpProtocolHandler is a ProtocolHandler for RDP scanning.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func rdpProtocolHandler(ip, hostname string, port int) []ScanResult {
+	results := collectTLSResults(ip, hostname, port, "rdp", rdpNegotiateTLS)
+	if len(results) == 0 {
+		logutil.DebugLog("RDP TLS scan failed for %s:%d", ip, port)
+		return nil
+	}
+	logutil.DebugLog("RDP TLS scan successful for %s:%d", ip, port)
+	return results
+}
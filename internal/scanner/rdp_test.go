@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rdpConnectionConfirm builds a TPKT-framed X.224 Connection Confirm, followed by negData
+// (an RDP Negotiation Response or Failure, or nothing).
+func rdpConnectionConfirm(negData []byte) []byte {
+	tpdu := append([]byte{0, x224TypeConnectionConfirm, 0, 0, 0x12, 0x34, 0}, negData...)
+	tpdu[0] = byte(len(tpdu) - 1)
+	tpkt := []byte{3, 0, 0, 0}
+	binary.BigEndian.PutUint16(tpkt[2:4], uint16(4+len(tpdu)))
+	return append(tpkt, tpdu...)
+}
+
+// rdpNegData builds an RDP Negotiation Response or Failure with the given value.
+func rdpNegData(negType byte, value uint32) []byte {
+	data := []byte{negType, 0, 8, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(data[4:8], value)
+	return data
+}
+
+// fakeRDPServer reads the X.224 Connection Request, answers with reply and, if startTLS is
+// set, performs a TLS handshake.
+func fakeRDPServer(t *testing.T, reply []byte, startTLS bool) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		request := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		// The request must ask for TLS and CredSSP
+		if request[1] != 0xe0 || binary.LittleEndian.Uint32(request[len(request)-4:]) != rdpProtocolSSL|rdpProtocolHybrid {
+			return
+		}
+		conn.Write(reply)
+		if startTLS {
+			serveTLS(conn, cfg)
+		}
+	})
+}
+
+// dialRDP connects to a fake RDP server and runs the negotiation.
+func dialRDP(t *testing.T, ip string, port int) error {
+	t.Helper()
+	conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return rdpNegotiateTLS(conn)
+}
+
+func TestRDPNegotiateTLS(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		name    string
+		reply   []byte
+		wantErr string
+	}{
+		{"PROTOCOL_SSL", rdpConnectionConfirm(rdpNegData(rdpNegTypeResponse, rdpProtocolSSL)), ""},
+		{"PROTOCOL_HYBRID", rdpConnectionConfirm(rdpNegData(rdpNegTypeResponse, rdpProtocolHybrid)), ""},
+		{"PROTOCOL_RDP", rdpConnectionConfirm(rdpNegData(rdpNegTypeResponse, 0)), "non-TLS protocol"},
+		// SSL_NOT_ALLOWED_BY_SERVER
+		{"RDP_NEG_FAILURE", rdpConnectionConfirm(rdpNegData(rdpNegTypeFailure, 2)), "failed with code 2"},
+		{"no negotiation data", rdpConnectionConfirm(nil), "standard RDP security"},
+		{"short TPKT reply", []byte{3, 0, 0, 19, 14, x224TypeConnectionConfirm}, "read failed"},
+		{"short TPKT header", []byte{3, 0}, "read failed"},
+		{"invalid TPKT length", []byte{3, 0, 0, 5, 0}, "invalid TPKT length"},
+		{"wrong TPKT version", []byte{2, 0, 0, 19}, "TPKT version"},
+		{"disconnect request", []byte{3, 0, 0, 11, 6, 0x80, 0, 0, 0, 0, 0}, "TPDU type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port := fakeRDPServer(t, tt.reply, false)
+			err := dialRDP(t, ip, port)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCollectTLSResultsRDP(t *testing.T) {
+	useTestConfig(t)
+	ip, port := fakeRDPServer(t, rdpConnectionConfirm(rdpNegData(rdpNegTypeResponse, rdpProtocolHybrid)), true)
+	results := collectTLSResults(ip, testHostname, port, "rdp", rdpNegotiateTLS)
+	if len(results) != 1 || len(results[0].Certificates) != 1 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
@@ -0,0 +1,349 @@
+// revocation.go provides revocation checking via OCSP and CRL for NextPKI.
+// Each certificate of a chain is checked with the OCSP responder from its AIA extension,
+// falling back to its CRL distribution points. CRLs are cached in memory and on disk
+// until their nextUpdate, so large CRLs are downloaded once per publication.
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPResponseSize and maxCRLSize bound the size of downloaded revocation data.
+const (
+	maxOCSPResponseSize = 1 << 20
+	maxCRLSize          = 64 << 20
+)
+
+// Revocation sources reported in RevocationInfo.Source.
+const (
+	revocationSourceOCSP = "ocsp"
+	revocationSourceCRL  = "crl"
+)
+
+// minCRLCacheTTL is how long a CRL without nextUpdate is cached after it was downloaded.
+const minCRLCacheTTL = time.Hour
+
+// cachedCRL is a parsed CRL and the time it was downloaded.
+type cachedCRL struct {
+	crl     *x509.RevocationList
+	fetched time.Time
+}
+
+// crlCache holds cachedCRL entries by distribution point URL.
+var crlCache sync.Map
+
+// revocationReasons maps CRL reason codes to their names (RFC 5280, 5.3.1).
+var revocationReasons = map[int]string{
+	ocsp.Unspecified:          "unspecified",
+	ocsp.KeyCompromise:        "keyCompromise",
+	ocsp.CACompromise:         "cACompromise",
+	ocsp.AffiliationChanged:   "affiliationChanged",
+	ocsp.Superseded:           "superseded",
+	ocsp.CessationOfOperation: "cessationOfOperation",
+	ocsp.CertificateHold:      "certificateHold",
+	ocsp.RemoveFromCRL:        "removeFromCRL",
+	ocsp.PrivilegeWithdrawn:   "privilegeWithdrawn",
+	ocsp.AACompromise:         "aACompromise",
+}
+
+// RevocationInfo describes the revocation status of one certificate of a chain.
+type RevocationInfo struct {
+	Subject    string `json:"subject"`               // Subject of the certificate
+	Serial     string `json:"serial"`                // Hex-encoded serial number
+	Status     string `json:"status"`                // good, revoked or unknown
+	Source     string `json:"source,omitempty"`      // Optional: where the status came from (ocsp/crl)
+	Reason     string `json:"reason,omitempty"`      // Optional: revocation reason name (e.g. "keyCompromise")
+	ReasonCode *int   `json:"reason_code,omitempty"` // Optional: revocation reason code (RFC 5280)
+	RevokedAt  int64  `json:"revoked_at,omitempty"`  // Optional: Unix timestamp of the revocation
+	Error      string `json:"error,omitempty"`       // Optional: why the status is unknown
+}
+
+// revocationCheckEnabled reports whether revocation checking is enabled globally or for the
+// include_list entry matching the target.
+func revocationCheckEnabled(ip, hostname string, port int) bool {
+	if shared.Config.CheckRevocation {
+		return true
+	}
+	entry := includeEntryFor(ip, hostname, port)
+	return entry != nil && entry.CheckRevocation
+}
+
+// revocationHTTPClient returns the HTTP client for OCSP and CRL requests.
+func revocationHTTPClient() *http.Client {
+	return &http.Client{Timeout: time.Duration(shared.Config.HTTPTimeoutMs) * time.Millisecond}
+}
+
+// setRevoked fills in the revocation details of a revoked certificate.
+func (info *RevocationInfo) setRevoked(reason int, revokedAt time.Time) {
+	info.Status = "revoked"
+	info.Reason = revocationReasons[reason]
+	info.ReasonCode = &reason
+	info.RevokedAt = revokedAt.Unix()
+}
+
+// queryOCSP asks the OCSP responders of cert for its status.
+func queryOCSP(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = fmt.Errorf("no OCSP responder")
+	for _, url := range cert.OCSPServer {
+		var resp *http.Response
+		resp, err = revocationHTTPClient().Post(url, "application/ocsp-request", bytes.NewReader(request))
+		if err != nil {
+			continue
+		}
+		var body []byte
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("OCSP responder %s returned status %d", url, resp.StatusCode)
+			continue
+		}
+		var parsed *ocsp.Response
+		if parsed, err = ocsp.ParseResponseForCert(body, cert, issuer); err == nil {
+			return parsed, nil
+		}
+	}
+	return nil, err
+}
+
+// crlCachePath returns the disk cache file of the CRL published at url.
+func crlCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir(shared.Config.CRLCacheDir, "crl"), hex.EncodeToString(sum[:])+".crl")
+}
+
+// crlCurrent reports whether a CRL downloaded at fetched has not passed its nextUpdate. CRLs
+// without nextUpdate are considered current for minCRLCacheTTL after the download.
+func crlCurrent(crl *x509.RevocationList, fetched time.Time) bool {
+	if crl.NextUpdate.IsZero() {
+		return time.Since(fetched) < minCRLCacheTTL
+	}
+	return time.Now().Before(crl.NextUpdate)
+}
+
+// parseCRL parses a DER or PEM encoded CRL and checks that issuer signed it.
+func parseCRL(data []byte, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// storeCachedCRL writes a downloaded CRL to the disk cache, renaming it into place.
+func storeCachedCRL(url string, data []byte) {
+	dir := cacheDir(shared.Config.CRLCacheDir, "crl")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logutil.ErrorLog("Failed to create CRL cache directory: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "crl-*.tmp")
+	if err != nil {
+		logutil.ErrorLog("Failed to write CRL cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), crlCachePath(url))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logutil.ErrorLog("Failed to write CRL cache: %v", err)
+	}
+}
+
+// fetchCRL returns the current CRL published at url from the memory cache, the disk cache
+// or the distribution point, in that order.
+func fetchCRL(url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	if value, ok := crlCache.Load(url); ok {
+		cached := value.(cachedCRL)
+		if crlCurrent(cached.crl, cached.fetched) && cached.crl.CheckSignatureFrom(issuer) == nil {
+			return cached.crl, nil
+		}
+	}
+	// The modification time of a disk cache file is the time of the download
+	if info, err := os.Stat(crlCachePath(url)); err == nil {
+		if data, err := os.ReadFile(crlCachePath(url)); err == nil {
+			if crl, err := parseCRL(data, issuer); err == nil && crlCurrent(crl, info.ModTime()) {
+				crlCache.Store(url, cachedCRL{crl: crl, fetched: info.ModTime()})
+				return crl, nil
+			}
+		}
+	}
+
+	resp, err := revocationHTTPClient().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL distribution point %s returned status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	crl, err := parseCRL(data, issuer)
+	if err != nil {
+		return nil, err
+	}
+	fetched := time.Now()
+	crlCache.Store(url, cachedCRL{crl: crl, fetched: fetched})
+	if crlCurrent(crl, fetched) {
+		storeCachedCRL(url, data)
+	}
+	return crl, nil
+}
+
+// checkCRL looks cert up in the CRLs of its distribution points.
+func checkCRL(cert, issuer *x509.Certificate, info *RevocationInfo) error {
+	err := fmt.Errorf("no CRL distribution point")
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue // e.g. LDAP distribution points
+		}
+		var crl *x509.RevocationList
+		if crl, err = fetchCRL(url, issuer); err != nil {
+			continue
+		}
+		info.Status = "good"
+		info.Source = revocationSourceCRL
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				info.setRevoked(entry.ReasonCode, entry.RevocationTime)
+				break
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// checkRevocation determines the revocation status of cert, issued by issuer, via OCSP and,
+// if OCSP gives no answer, via CRL.
+func checkRevocation(cert, issuer *x509.Certificate) RevocationInfo {
+	info := RevocationInfo{
+		Subject: cert.Subject.String(),
+		Serial:  cert.SerialNumber.Text(16),
+		Status:  "unknown",
+	}
+	if issuer == nil {
+		info.Error = "issuer not available"
+		return info
+	}
+
+	var errs []string
+	resp, err := queryOCSP(cert, issuer)
+	switch {
+	case err != nil:
+		errs = append(errs, "ocsp: "+err.Error())
+	case resp.Status == ocsp.Good:
+		info.Status = "good"
+		info.Source = revocationSourceOCSP
+		return info
+	case resp.Status == ocsp.Revoked:
+		info.Source = revocationSourceOCSP
+		info.setRevoked(resp.RevocationReason, resp.RevokedAt)
+		return info
+	default:
+		errs = append(errs, "ocsp: responder does not know the certificate")
+	}
+
+	if err := checkCRL(cert, issuer, &info); err != nil {
+		errs = append(errs, "crl: "+err.Error())
+		info.Error = strings.Join(errs, "; ")
+	}
+	return info
+}
+
+// trustedIssuer returns the issuer of cert from the trust store, or nil if cert does not
+// verify against it.
+func trustedIssuer(cert *x509.Certificate) *x509.Certificate {
+	roots, trusted := trustStore()
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: trusted,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil || len(chains[0]) < 2 {
+		return nil
+	}
+	return chains[0][1]
+}
+
+// chainRevocation checks every certificate of a chain (leaf first). The issuer of each
+// certificate is the next one in the chain. For the last one it is taken from the trust store
+// or, if fetch_intermediates is enabled, fetched via AIA.
+// Self-signed roots are reported as unknown, as they cannot be revoked.
+func chainRevocation(chain []*x509.Certificate) []RevocationInfo {
+	infos := make([]RevocationInfo, 0, len(chain))
+	for i, cert := range chain {
+		if isSelfSigned(cert) {
+			infos = append(infos, RevocationInfo{
+				Subject: cert.Subject.String(),
+				Serial:  cert.SerialNumber.Text(16),
+				Status:  "unknown",
+				Error:   "self-signed certificate",
+			})
+			continue
+		}
+		var issuer *x509.Certificate
+		if i+1 < len(chain) && cert.CheckSignatureFrom(chain[i+1]) == nil {
+			issuer = chain[i+1]
+		} else if issuer = trustedIssuer(cert); issuer == nil && shared.Config.FetchIntermediates {
+			issuer = fetchIssuer(cert)
+		}
+		infos = append(infos, checkRevocation(cert, issuer))
+	}
+	return infos
+}
+
+// annotateRevocation sets Revocation on the results of targets with revocation checking
+// enabled, with one entry per certificate in Certificates.
+func annotateRevocation(results []ScanResult) {
+	for i := range results {
+		if !revocationCheckEnabled(results[i].IP, results[i].Hostname, results[i].Port) {
+			continue
+		}
+		var chain []*x509.Certificate
+		for _, der := range decodeBase64Certs(results[i].Certificates) {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				logutil.DebugLog("Skipping revocation check of unparseable certificate: %v", err)
+				chain = nil
+				break
+			}
+			chain = append(chain, cert)
+		}
+		results[i].Revocation = chainRevocation(chain)
+	}
+}
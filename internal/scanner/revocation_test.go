@@ -0,0 +1,334 @@
+package scanner
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nextpki/certscan/internal/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+// crl returns a CRL signed by ca that lists the revoked certificates with reason
+// keyCompromise.
+func (ca *testIssuer) crl(t *testing.T, nextUpdate time.Time, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: nextUpdate,
+	}
+	for _, cert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+			ReasonCode:     ocsp.KeyCompromise,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// crlWithoutNextUpdate returns an empty CRL signed by ca that has no nextUpdate field, which
+// x509.CreateRevocationList cannot produce.
+func (ca *testIssuer) crlWithoutNextUpdate(t *testing.T) []byte {
+	t.Helper()
+	ecdsaWithSHA256 := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	tbs, err := asn1.Marshal(struct {
+		Version    int
+		Signature  pkix.AlgorithmIdentifier
+		Issuer     asn1.RawValue
+		ThisUpdate time.Time `asn1:"utc"`
+	}{1, ecdsaWithSHA256, asn1.RawValue{FullBytes: ca.cert.RawSubject}, time.Now().Add(-time.Minute).UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	signature, err := ecdsa.SignASN1(rand.Reader, ca.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS                asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}{asn1.RawValue{FullBytes: tbs}, ecdsaWithSHA256, asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// revocationServer serves an OCSP response on /ocsp and a CRL on /crl, and counts the
+// requests. Endpoints without data answer 404.
+type revocationServer struct {
+	*httptest.Server
+	ocsp, crl         []byte
+	ocspHits, crlHits atomic.Int32
+}
+
+func newRevocationServer(t *testing.T) *revocationServer {
+	s := &revocationServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		switch r.URL.Path {
+		case "/ocsp":
+			s.ocspHits.Add(1)
+			io.Copy(io.Discard, r.Body)
+			data = s.ocsp
+		case "/crl":
+			s.crlHits.Add(1)
+			data = s.crl
+		}
+		if data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// leaf issues a certificate from ca with the OCSP and CRL endpoints of the server.
+func (s *revocationServer) leaf(t *testing.T, ca *testIssuer) *x509.Certificate {
+	return ca.issue(t, &x509.Certificate{
+		OCSPServer:            []string{s.URL + "/ocsp"},
+		CRLDistributionPoints: []string{s.URL + "/crl"},
+	})
+}
+
+func TestCRLCurrent(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		nextUpdate time.Time
+		fetched    time.Time
+		want       bool
+	}{
+		{"before nextUpdate", now.Add(time.Hour), now.Add(-48 * time.Hour), true},
+		{"after nextUpdate", now.Add(-time.Minute), now.Add(-time.Hour), false},
+		{"no nextUpdate, fetched recently", time.Time{}, now.Add(-time.Minute), true},
+		{"no nextUpdate, fetched long ago", time.Time{}, now.Add(-minCRLCacheTTL - time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crl := &x509.RevocationList{NextUpdate: tt.nextUpdate}
+			if got := crlCurrent(crl, tt.fetched); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainRevocationLastIssuer(t *testing.T) {
+	cfg := useTestConfig(t)
+	root := newTestIssuer(t, "Revocation Root")
+	useTrustedRoots(t, cfg, root.cert)
+	intermediate := root.subordinate(t, "Revocation Intermediate", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	server, hits := aiaServer(t, map[string]*x509.Certificate{"/intermediate.der": intermediate.cert})
+	leaf := intermediate.issue(t, &x509.Certificate{IssuingCertificateURL: []string{server.URL + "/intermediate.der"}})
+
+	// The issuer of a chain's last certificate comes from the trust store
+	infos := chainRevocation([]*x509.Certificate{leaf, intermediate.cert})
+	if len(infos) != 2 || infos[1].Error == "issuer not available" {
+		t.Fatalf("issuer of the intermediate not found in the trust store: %+v", infos)
+	}
+
+	// Without fetch_intermediates, a missing issuer is not downloaded
+	infos = chainRevocation([]*x509.Certificate{leaf})
+	if infos[0].Error != "issuer not available" || hits["/intermediate.der"].Load() != 0 {
+		t.Fatalf("got %+v after %d downloads", infos[0], hits["/intermediate.der"].Load())
+	}
+	cfg.FetchIntermediates = true
+	infos = chainRevocation([]*x509.Certificate{leaf})
+	if infos[0].Error == "issuer not available" || hits["/intermediate.der"].Load() != 1 {
+		t.Fatalf("got %+v after %d downloads", infos[0], hits["/intermediate.der"].Load())
+	}
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newTestIssuer(t, "Revocation CA")
+	other := newTestIssuer(t, "Other CA")
+	tests := []struct {
+		name       string
+		ocsp       int // OCSP status, or -1 for no OCSP response
+		crlSigner  *testIssuer
+		crlListed  bool
+		wantStatus string
+		wantSource string
+		wantReason string
+		wantError  string
+	}{
+		{"OCSP good", ocsp.Good, nil, false, "good", revocationSourceOCSP, "", ""},
+		{"OCSP revoked", ocsp.Revoked, nil, false, "revoked", revocationSourceOCSP, "unspecified", ""},
+		{"OCSP unknown, CRL lists the certificate", ocsp.Unknown, ca, true, "revoked", revocationSourceCRL, "keyCompromise", ""},
+		{"OCSP unknown, CRL does not list the certificate", ocsp.Unknown, ca, false, "good", revocationSourceCRL, "", ""},
+		{"no OCSP, CRL miss", -1, ca, false, "good", revocationSourceCRL, "", ""},
+		{"CRL with bad signature", -1, other, true, "unknown", "", "", "crl: "},
+		{"no OCSP, no CRL", -1, nil, false, "unknown", "", "", "ocsp: OCSP responder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := useTestConfig(t)
+			cfg.CRLCacheDir = t.TempDir()
+			server := newRevocationServer(t)
+			leaf := server.leaf(t, ca)
+			if tt.ocsp >= 0 {
+				server.ocsp = ca.ocspResponse(t, leaf, tt.ocsp)
+			}
+			if tt.crlSigner != nil {
+				var revoked []*x509.Certificate
+				if tt.crlListed {
+					revoked = append(revoked, leaf)
+				}
+				server.crl = tt.crlSigner.crl(t, time.Now().Add(time.Hour), revoked...)
+			}
+
+			info := checkRevocation(leaf, ca.cert)
+			if info.Status != tt.wantStatus || info.Source != tt.wantSource || info.Reason != tt.wantReason {
+				t.Fatalf("got %+v", info)
+			}
+			if !strings.Contains(info.Error, tt.wantError) || (tt.wantError == "") != (info.Error == "") {
+				t.Fatalf("got error %q, want %q", info.Error, tt.wantError)
+			}
+			if tt.wantStatus == "revoked" && (info.ReasonCode == nil || info.RevokedAt == 0) {
+				t.Fatalf("revocation details missing: %+v", info)
+			}
+			// The CRL is only consulted when OCSP gives no answer
+			if tt.wantSource == revocationSourceOCSP && server.crlHits.Load() != 0 {
+				t.Fatal("CRL downloaded although OCSP answered")
+			}
+		})
+	}
+}
+
+func TestFetchCRLDiskCache(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.CRLCacheDir = t.TempDir()
+	ca := newTestIssuer(t, "CRL Cache CA")
+	server := newRevocationServer(t)
+	revoked := server.leaf(t, ca)
+	server.crl = ca.crl(t, time.Now().Add(time.Hour), revoked)
+	url := server.URL + "/crl"
+
+	if _, err := fetchCRL(url, ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	// After a restart the CRL is reloaded from disk instead of downloaded
+	crlCache.Delete(url)
+	crl, err := fetchCRL(url, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.crlHits.Load(); n != 1 {
+		t.Fatalf("CRL downloaded %d times, want 1", n)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 {
+		t.Fatalf("reloaded CRL has %d entries", len(crl.RevokedCertificateEntries))
+	}
+
+	// A cached CRL is not used for another issuer
+	crlCache.Delete(url)
+	if _, err := fetchCRL(url, newTestIssuer(t, "Other CA").cert); err == nil {
+		t.Fatal("CRL accepted for an issuer that did not sign it")
+	}
+}
+
+func TestFetchCRLWithoutNextUpdate(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.CRLCacheDir = t.TempDir()
+	ca := newTestIssuer(t, "CRL TTL CA")
+	server := newRevocationServer(t)
+	server.crl = ca.crlWithoutNextUpdate(t)
+	if crl, err := parseCRL(server.crl, ca.cert); err != nil || !crl.NextUpdate.IsZero() {
+		t.Fatalf("invalid test CRL: %v", err)
+	}
+	url := server.URL + "/crl"
+
+	for range 2 {
+		if _, err := fetchCRL(url, ca.cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crlCache.Delete(url)
+	if _, err := fetchCRL(url, ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.crlHits.Load(); n != 1 {
+		t.Fatalf("CRL downloaded %d times within its cache TTL, want 1", n)
+	}
+
+	// Once the minimum TTL has passed, the CRL is downloaded again
+	old := time.Now().Add(-minCRLCacheTTL - time.Minute)
+	if err := os.Chtimes(crlCachePath(url), old, old); err != nil {
+		t.Fatal(err)
+	}
+	crlCache.Delete(url)
+	if _, err := fetchCRL(url, ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.crlHits.Load(); n != 2 {
+		t.Fatalf("CRL downloaded %d times after its cache TTL, want 2", n)
+	}
+}
+
+func TestRevocationFilteredWithExcludedCerts(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.CheckRevocation = true
+	cfg.CRLCacheDir = t.TempDir()
+	root := newTestIssuer(t, "Revocation Root")
+	useTrustedRoots(t, cfg, root.cert)
+	intermediate := root.subordinate(t, "Revocation Intermediate", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	server := newRevocationServer(t)
+	leaf := server.leaf(t, intermediate)
+	server.ocsp = intermediate.ocspResponse(t, leaf, ocsp.Revoked)
+	chain := []string{
+		base64.StdEncoding.EncodeToString(leaf.Raw),
+		base64.StdEncoding.EncodeToString(intermediate.cert.Raw),
+	}
+
+	tests := []struct {
+		name       string
+		exclude    string
+		wantCert   *x509.Certificate
+		wantStatus string
+	}{
+		{"intermediate excluded", "Revocation Intermediate", leaf, "revoked"},
+		{"leaf excluded", testHostname, intermediate.cert, "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.ExcludeCerts = []config.ExcludeCertRule{{CN: tt.exclude}}
+			results := []ScanResult{{IP: "127.0.0.1", Hostname: testHostname, Port: 443, Certificates: slices.Clone(chain)}}
+			annotateRevocation(results)
+			filterResultCerts(results)
+
+			result := results[0]
+			if len(result.Certificates) != 1 || len(result.Revocation) != 1 {
+				t.Fatalf("got %d certificates and %d revocation entries", len(result.Certificates), len(result.Revocation))
+			}
+			info := result.Revocation[0]
+			if info.Serial != tt.wantCert.SerialNumber.Text(16) || info.Status != tt.wantStatus {
+				t.Fatalf("got %+v for %s", info, tt.wantCert.Subject)
+			}
+		})
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,27 +26,62 @@ import (
 	utls "github.com/refraction-networking/utls"
 )
 
+// TLS modes recorded in ScanResult.TLSMode.
+const (
+	tlsModeImplicit = "implicit" // TLS handshake directly after TCP connect
+	tlsModeStartTLS = "starttls" // TLS negotiated from a plaintext protocol dialogue
+)
+
 // AllowedProtocols lists all supported protocol names for scanning.
 // Used to validate and dispatch protocol-specific handlers.
-var AllowedProtocols = []string{"http1", "h2", "h3", "smtp", "ldap", "imap", "pop3", "custom"}
+var AllowedProtocols = []string{"http1", "h2", "h3", "smtp", "ldap", "imap", "pop3", "postgres", "mysql", "ftp", "xmpp", "xmpp-server", "rdp", "mqtt", "amqp", "redis", "kafka", "nntp", "sieve", "irc", "lmtp", "custom", "auto"}
+
+// PayloadSchemaVersion is the version of the webhook payload schema (see docs/webhook-schema.md).
+// It is incremented with every release that adds or changes fields of Payload or ScanResult.
+const PayloadSchemaVersion = 2
 
 // Payload represents the data sent to the webhook, including agent and scan results.
-// Contains the primary IP, machine ID, and a list of scan results.
+// Contains the schema version, primary IP, machine ID, and a list of scan results.
 type Payload struct {
-	PrimaryIP   string       `json:"primary_ip,omitempty"` // The primary IP address of the scanning agent
-	MachineID   string       `json:"machine_id,omitempty"` // Unique machine identifier
-	ScanResults []ScanResult `json:"scan_results"`         // List of scan results
+	SchemaVersion int          `json:"schema_version"`       // Version of the payload schema (PayloadSchemaVersion)
+	PrimaryIP     string       `json:"primary_ip,omitempty"` // The primary IP address of the scanning agent
+	MachineID     string       `json:"machine_id,omitempty"` // Unique machine identifier
+	ScanResults   []ScanResult `json:"scan_results"`         // List of scan results
 }
 
 // ScanResult holds the result of a single port scan, including certificates and metadata.
 // Used for reporting to the webhook.
 type ScanResult struct {
-	IP            string   `json:"ip"`                       // Target IP address
-	Port          int      `json:"port"`                     // Target port
-	Hostname      string   `json:"hostname,omitempty"`       // Optional: original hostname
-	HandshakeType string   `json:"handshake_type,omitempty"` // TLS handshake type (ecdsa/rsa)
-	Certificates  []string `json:"certificates,omitempty"`   // Base64-encoded DER certificates
-	Timestamp     int64    `json:"timestamp"`                // Unix timestamp of scan
+	IP                   string            `json:"ip"`                               // Target IP address
+	Port                 int               `json:"port"`                             // Target port
+	Hostname             string            `json:"hostname,omitempty"`               // Optional: original hostname
+	SNI                  string            `json:"sni"`                              // Server name sent in the ClientHello (empty for no SNI)
+	SNINames             []string          `json:"sni_names,omitempty"`              // All server names that returned the same chain
+	HandshakeType        string            `json:"handshake_type,omitempty"`         // Handshake profile that retrieved the chain (ecdsa/rsa/ed25519/mldsa)
+	HandshakeTypes       []string          `json:"handshake_types,omitempty"`        // All handshake profiles that returned the same chain
+	TLSMode              string            `json:"tls_mode,omitempty"`               // How TLS was reached (implicit/starttls)
+	TLSModeFallback      bool              `json:"tls_mode_fallback,omitempty"`      // Optional: implicit TLS was used because the SMTP server sent no banner
+	TLSVersion           string            `json:"tls_version,omitempty"`            // Negotiated TLS version (e.g. "TLS 1.2")
+	CipherSuite          string            `json:"cipher_suite,omitempty"`           // Negotiated cipher suite
+	ALPN                 string            `json:"alpn,omitempty"`                   // Optional: negotiated ALPN protocol
+	KeyExchangeGroup     string            `json:"key_exchange_group,omitempty"`     // Optional: negotiated key exchange group (e.g. "X25519")
+	HandshakeLatencyMs   int64             `json:"handshake_latency_ms,omitempty"`   // Duration of the TLS handshake in milliseconds
+	SupportedTLSVersions []string          `json:"supported_tls_versions,omitempty"` // Optional: versions accepted by the server (enumerate_tls_versions)
+	CipherSuites         []CipherSuiteInfo `json:"cipher_suites,omitempty"`          // Optional: accepted cipher suites in server order (enumerate_cipher_suites)
+	ServerVersion        string            `json:"server_version,omitempty"`         // Optional: server software version announced by the service
+	ServiceType          string            `json:"service_type,omitempty"`           // Optional: service confirmed by a post-handshake probe (mqtt/amqp/redis/kafka)
+	KeyExchange          *KeyExchangeInfo  `json:"key_exchange,omitempty"`           // Optional: selected key exchange group and HelloRetryRequest (detect_key_exchange)
+	OCSPStaple           *OCSPStapleInfo   `json:"ocsp_staple,omitempty"`            // Optional: parsed OCSP response stapled by the server
+	SCTs                 []SCTInfo         `json:"scts,omitempty"`                   // Optional: SCTs delivered via TLS, OCSP or embedded in the leaf certificate
+	ClientCertRequested  bool              `json:"client_cert_requested"`            // Whether the server requested a client certificate (mTLS)
+	AcceptableCAs        []string          `json:"acceptable_cas,omitempty"`         // Optional: CA distinguished names advertised in the CertificateRequest
+	Validation           *ChainValidation  `json:"validation,omitempty"`             // Optional: chain verification verdict against the trust store
+	ChainIncomplete      bool              `json:"chain_incomplete"`                 // Whether the server omitted intermediates that were fetched via AIA
+	FetchedIntermediates int               `json:"fetched_intermediates,omitempty"`  // Optional: number of trailing certificates fetched via AIA
+	Revocation           []RevocationInfo  `json:"revocation,omitempty"`             // Optional: revocation status per entry of Certificates (check_revocation)
+	DetectedProtocol     string            `json:"detected_protocol,omitempty"`      // Optional: protocol identified by auto-detection
+	Certificates         []string          `json:"certificates,omitempty"`           // Base64-encoded DER certificates
+	Timestamp            int64             `json:"timestamp"`                        // Unix timestamp of scan
 }
 
 // matchWildcard checks if s matches pattern (supports '*' wildcard)
@@ -82,21 +119,81 @@ func isCertExcluded(cert *x509.Certificate, rules []config.ExcludeCertRule) bool
 	return false
 }
 
-// filterCerts filters out excluded certs and returns a slice of base64 DER strings
-func filterCerts(derCerts [][]byte, rules []config.ExcludeCertRule) []string {
-	var filtered []string
-	for _, der := range derCerts {
-		cert, err := x509.ParseCertificate(der)
-		if err != nil {
-			continue // skip invalid certs
+// certIncluded reports whether a base64 DER certificate is valid and not excluded by rules.
+func certIncluded(b64 string, rules []config.ExcludeCertRule) bool {
+	der, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return false // skip invalid certs
+	}
+	if isCertExcluded(cert, rules) {
+		logutil.DebugLog("Skipping certificate due to exclude_certs filter: CN=%s Issuer=%s", cert.Subject.CommonName, cert.Issuer.String())
+		return false
+	}
+	return true
+}
+
+// filterResultCerts applies the exclude_certs rules to the certificates of results. The
+// revocation entry of a removed certificate is removed with it, so Revocation stays aligned
+// with Certificates, and FetchedIntermediates keeps counting the trailing fetched entries.
+func filterResultCerts(results []ScanResult) {
+	rules := shared.Config.ExcludeCerts
+	for i := range results {
+		result := &results[i]
+		presented := max(len(result.Certificates)-result.FetchedIntermediates, 0)
+		revocation := len(result.Revocation) == len(result.Certificates)
+		var certs []string
+		var infos []RevocationInfo
+		fetched := 0
+		for j, cert := range result.Certificates {
+			if !certIncluded(cert, rules) {
+				continue
+			}
+			certs = append(certs, cert)
+			if revocation {
+				infos = append(infos, result.Revocation[j])
+			}
+			if j >= presented {
+				fetched++
+			}
 		}
-		if isCertExcluded(cert, rules) {
-			logutil.DebugLog("Skipping certificate due to exclude_certs filter: CN=%s Issuer=%s", cert.Subject.CommonName, cert.Issuer.String())
+		result.Certificates = certs
+		result.FetchedIntermediates = fetched
+		if revocation {
+			result.Revocation = infos
+		}
+	}
+}
+
+// includeEntryFor returns the include_list entry that produced a scan of ip/hostname on port,
+// preferring host:port entries over plain host entries over CIDR entries. Returns nil if none matches.
+func includeEntryFor(ip, hostname string, port int) *config.IncludeEntry {
+	var hostMatch, cidrMatch *config.IncludeEntry
+	for i := range shared.Config.IncludeList {
+		entry := &shared.Config.IncludeList[i]
+		if _, ipnet, err := net.ParseCIDR(entry.Target); err == nil {
+			if cidrMatch == nil && ipnet.Contains(net.ParseIP(ip)) {
+				cidrMatch = entry
+			}
+			continue
+		}
+		if host, p, err := net.SplitHostPort(entry.Target); err == nil {
+			if strings.EqualFold(host, hostname) && p == strconv.Itoa(port) {
+				return entry
+			}
 			continue
 		}
-		filtered = append(filtered, base64.StdEncoding.EncodeToString(der))
+		if hostMatch == nil && strings.EqualFold(entry.Target, hostname) {
+			hostMatch = entry
+		}
+	}
+	if hostMatch != nil {
+		return hostMatch
 	}
-	return filtered
+	return cidrMatch
 }
 
 // sendToWebhook posts scan results to the configured webhook URL as a JSON payload.
@@ -113,9 +210,10 @@ func sendToWebhook(results []ScanResult, url string) {
 	webhookTimeout = webhookTimeout * time.Millisecond
 
 	payload := Payload{
-		PrimaryIP:   shared.GetPrimaryIP(),
-		MachineID:   shared.GetMachineID(),
-		ScanResults: results,
+		SchemaVersion: PayloadSchemaVersion,
+		PrimaryIP:     shared.GetPrimaryIP(),
+		MachineID:     shared.GetMachineID(),
+		ScanResults:   results,
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -154,9 +252,11 @@ func sendToWebhook(results []ScanResult, url string) {
 			// get a new token
 			if shared.Config == nil || shared.Config.Token == "" {
 				fmt.Println("\n\nNo token provided.")
-				fmt.Println("You can register your system in seconds with the following command:\n")
+				fmt.Println("You can register your system in seconds with the following command:")
+				fmt.Println()
 				fmt.Println("  curl -sSf https://cd.ultrapki.com/sh | sh")
-				fmt.Println("\nThis will generate a token for your system and show you how to add it to your config.\n")
+				fmt.Println("\nThis will generate a token for your system and show you how to add it to your config.")
+				fmt.Println()
 				os.Exit(1)
 			}
 		}
@@ -165,6 +265,7 @@ func sendToWebhook(results []ScanResult, url string) {
 
 // ResolveAndScan resolves a hostname (or IP string) and scans each resolved IP.
 // Skips IPv6 addresses if not enabled in config. Used for hostnames and CIDR expansion.
+// The protocol is selected per port (see portProtocol).
 // Parameters:
 //
 //	host:  Hostname or IP string
@@ -174,7 +275,7 @@ func ResolveAndScan(host string, ports []int) {
 	if ip != nil {
 
 		logutil.DebugLog("Scanning resolved IP: %s", ip.String())
-		ScanAndSendWithProtocol(ip.String(), host, ports, "http1")
+		ScanAndSendWithProtocol(ip.String(), host, ports, "")
 		return
 	}
 
@@ -188,10 +289,23 @@ func ResolveAndScan(host string, ports []int) {
 	for _, ip := range ips {
 		// Debug output for each resolved IP
 		logutil.DebugLog("Scanning resolved IP: %s", ip.String())
-		ScanAndSendWithProtocol(ip.String(), host, ports, "http1")
+		ScanAndSendWithProtocol(ip.String(), host, ports, "")
 	}
 }
 
+// defaultALPNProtocols are offered in the ClientHello unless protocolALPN overrides them.
+var defaultALPNProtocols = []string{"h2", "http/1.1", "http/1.0", "h3", "spdy/3.1", "acme-tls/1"}
+
+// protocolALPN maps protocols that require a dedicated ALPN identifier to the identifiers to offer.
+var protocolALPN = map[string][]string{
+	"postgres": {"postgresql"},
+	"xmpp":     {"xmpp-client"}, // XEP-0368 direct TLS
+}
+
+// connPreamble performs a protocol-specific plaintext negotiation on a fresh connection,
+// leaving it ready for the TLS ClientHello (e.g. PostgreSQL SSLRequest).
+type connPreamble func(conn net.Conn) error
+
 // tlsHandshakeAndCollectWithTimeout performs a TLS handshake with the given cipher suites and collects certificates.
 // Uses utls for full ClientHello customization. Skips HTTP header collection for utls.UConn.
 // Parameters:
@@ -199,14 +313,19 @@ func ResolveAndScan(host string, ports []int) {
 //	ip:            Target IP address
 //	hostname:      SNI/Host header
 //	port:          Target port
-//	suites:        Cipher suites to offer
-//	handshakeType: "ecdsa" or "rsa"
-//	proto:         Protocol string (e.g., "http1")
+//	handshakeType: Handshake profile name (e.g. "ecdsa", "rsa", "ed25519", "mldsa")
+//	proto:         Protocol string (e.g., "http1"), selects the offered ALPN identifiers
+//	preamble:      Optional plaintext negotiation run before the handshake (nil for implicit TLS)
 //	dialTimeout:   Timeout for TCP dial
 //
 // Returns: ScanResult or error
-func tlsHandshakeAndCollectWithTimeout(ip, hostname string, port int, suites []uint16, handshakeType string, proto string, dialTimeout time.Duration) (*ScanResult, error) {
-	address := fmt.Sprintf("%s:%d", ip, port)
+func tlsHandshakeAndCollectWithTimeout(ip, hostname string, port int, handshakeType string, proto string, preamble connPreamble, dialTimeout time.Duration) (*ScanResult, error) {
+	profile, ok := handshakeProfiles[handshakeType]
+	if !ok {
+		return nil, fmt.Errorf("unknown handshake profile %q", handshakeType)
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 	dialer := &net.Dialer{Timeout: dialTimeout}
 	conn, err := dialer.Dial("tcp", address)
 	if err != nil {
@@ -214,45 +333,118 @@ func tlsHandshakeAndCollectWithTimeout(ip, hostname string, port int, suites []u
 	}
 	defer conn.Close()
 
-	var signatureAlgs []utls.SignatureScheme
-	if handshakeType == "ecdsa" {
-		signatureAlgs = []utls.SignatureScheme{
-			utls.ECDSAWithP256AndSHA256,
-			utls.ECDSAWithP384AndSHA384,
-			utls.ECDSAWithP521AndSHA512,
-		}
-	} else if handshakeType == "rsa" {
-		signatureAlgs = []utls.SignatureScheme{
-			utls.PKCS1WithSHA256,
-			utls.PKCS1WithSHA384,
-			utls.PKCS1WithSHA512,
-			utls.PSSWithSHA256,
-			utls.PSSWithSHA384,
-			utls.PSSWithSHA512,
+	tlsMode := tlsModeImplicit
+	if preamble != nil {
+		conn.SetDeadline(time.Now().Add(starttlsIOTimeout))
+		if err := preamble(conn); err != nil {
+			return nil, err
 		}
+		tlsMode = tlsModeStartTLS
+	}
+
+	alpn := defaultALPNProtocols
+	if p, ok := protocolALPN[proto]; ok {
+		alpn = p
 	}
 
+	// The handshake is recorded to read the key exchange group, and for TLS 1.3 only profiles
+	// to recover certificates the TLS stack cannot handle from the encrypted handshake flight
+	var keyLog bytes.Buffer
+	rc := &recordingConn{Conn: conn}
+	clientAuth := &clientCertRequest{}
 	tlsConfig := &utls.Config{
-		ServerName:         hostname,
-		InsecureSkipVerify: true, // Allow all certs, including expired/invalid
+		ServerName:           hostname,
+		InsecureSkipVerify:   true, // Allow all certs, including expired/invalid
+		KeyLogWriter:         &keyLog,
+		GetClientCertificate: clientAuth.getUClientCertificate,
 	}
-	uconn := utls.UClient(conn, tlsConfig, utls.HelloCustom)
+	uconn := utls.UClient(rc, tlsConfig, utls.HelloCustom)
 	spec := &utls.ClientHelloSpec{
-		CipherSuites: suites,
+		CipherSuites: profile.suites,
 		Extensions: []utls.TLSExtension{
 			&utls.SNIExtension{ServerName: hostname},
 			&utls.SupportedCurvesExtension{Curves: []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}},
 			&utls.SupportedPointsExtension{SupportedPoints: []byte{0}}, // uncompressed
-			&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: signatureAlgs},
-			&utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1", "http/1.0", "h3", "spdy/3.1", "acme-tls/1"}},
+			&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: profile.signatureAlgs},
+			&utls.ALPNExtension{AlpnProtocols: alpn},
+			&utls.StatusRequestExtension{},
+			&utls.SCTExtension{},
 		},
 	}
+	if profile.tls13Only {
+		spec.Extensions = append(spec.Extensions,
+			&utls.SupportedVersionsExtension{Versions: []uint16{utls.VersionTLS13}},
+			&utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: utls.X25519}}},
+			&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+		)
+	}
 	if err := uconn.ApplyPreset(spec); err != nil {
 		return nil, err
 	}
-	if err := uconn.Handshake(); err != nil {
-		return nil, err
+	start := time.Now()
+	err = uconn.Handshake()
+	if err != nil && clientAuth.rejectedAfterRequest(len(uconn.ConnectionState().PeerCertificates)) {
+		logutil.DebugLog("Handshake with %s:%d rejected without client certificate: %v", ip, port, err)
+		err = nil
 	}
+	if err != nil {
+		if !profile.tls13Only {
+			return nil, err
+		}
+		flight, recoverErr := recoverTLS13Flight(rc.received.Bytes(), keyLog.Bytes())
+		if recoverErr != nil {
+			return nil, fmt.Errorf("%w (certificate recovery: %v)", err, recoverErr)
+		}
+		version, suite, _ := serverHelloSelection(rc.received.Bytes())
+		certs := make([]string, 0, len(flight.certificates))
+		for _, der := range flight.certificates {
+			certs = append(certs, base64.StdEncoding.EncodeToString(der))
+		}
+		var parsed []*x509.Certificate
+		for _, der := range flight.certificates {
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				parsed = append(parsed, cert)
+			}
+		}
+		var leaf *x509.Certificate
+		if len(parsed) > 0 {
+			leaf = parsed[0]
+		}
+		if profile.mldsaOnly && (leaf == nil || !isMLDSAKey(leaf)) {
+			return nil, fmt.Errorf("%w (recovered leaf certificate has no ML-DSA key)", err)
+		}
+		if flight.certRequested {
+			clientAuth.record(flight.acceptableCAs)
+		}
+		fetched, validation := checkChain(parsed, ip, hostname)
+		staple, scts := collectStapledData(parsed, fetched, flight.ocspResponse, flight.scts)
+		for _, cert := range fetched {
+			certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		return &ScanResult{
+			IP:                   ip,
+			Port:                 port,
+			Hostname:             hostname,
+			SNI:                  sniValue(hostname),
+			HandshakeType:        handshakeType,
+			TLSMode:              tlsMode,
+			TLSVersion:           tls.VersionName(version),
+			CipherSuite:          tls.CipherSuiteName(suite),
+			ALPN:                 flight.alpn,
+			KeyExchangeGroup:     negotiatedGroup(rc.received.Bytes()),
+			HandshakeLatencyMs:   time.Since(start).Milliseconds(),
+			OCSPStaple:           staple,
+			SCTs:                 scts,
+			ClientCertRequested:  clientAuth.requested,
+			AcceptableCAs:        clientAuth.acceptableCAs,
+			Validation:           validation,
+			ChainIncomplete:      len(fetched) > 0,
+			FetchedIntermediates: len(fetched),
+			Certificates:         certs,
+			Timestamp:            time.Now().Unix(),
+		}, nil
+	}
+	latency := time.Since(start)
 
 	certs := []string{}
 	state := uconn.ConnectionState()
@@ -262,55 +454,213 @@ func tlsHandshakeAndCollectWithTimeout(ip, hostname string, port int, suites []u
 	if len(certs) == 0 {
 		return nil, fmt.Errorf("no certs found")
 	}
+	// A completed handshake means the server fell back to a key the TLS stack supports
+	if profile.mldsaOnly && !isMLDSAKey(state.PeerCertificates[0]) {
+		return nil, fmt.Errorf("server presented a %s key instead of ML-DSA", state.PeerCertificates[0].PublicKeyAlgorithm)
+	}
+	fetched, validation := checkChain(state.PeerCertificates, ip, hostname)
+	staple, scts := collectStapledData(state.PeerCertificates, fetched, state.OCSPResponse, state.SignedCertificateTimestamps)
+	for _, cert := range fetched {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
 	return &ScanResult{
-		IP:            ip,
-		Port:          port,
-		Hostname:      hostname,
-		HandshakeType: handshakeType,
-		Certificates:  certs,
-		Timestamp:     time.Now().Unix(),
+		IP:                   ip,
+		Port:                 port,
+		Hostname:             hostname,
+		SNI:                  sniValue(hostname),
+		HandshakeType:        handshakeType,
+		TLSMode:              tlsMode,
+		TLSVersion:           tls.VersionName(state.Version),
+		CipherSuite:          tls.CipherSuiteName(state.CipherSuite),
+		ALPN:                 state.NegotiatedProtocol,
+		KeyExchangeGroup:     negotiatedGroup(rc.received.Bytes()),
+		HandshakeLatencyMs:   latency.Milliseconds(),
+		ServiceType:          probeService(uconn, proto),
+		OCSPStaple:           staple,
+		SCTs:                 scts,
+		ClientCertRequested:  clientAuth.requested,
+		AcceptableCAs:        clientAuth.acceptableCAs,
+		Validation:           validation,
+		ChainIncomplete:      len(fetched) > 0,
+		FetchedIntermediates: len(fetched),
+		Certificates:         certs,
+		Timestamp:            time.Now().Unix(),
 	}, nil
 }
 
+// starttlsIOTimeout bounds the plaintext dialogue and TLS upgrade of STARTTLS-style protocols,
+// so a silent or misbehaving server cannot stall a scan worker indefinitely.
+const starttlsIOTimeout = 10 * time.Second
+
+// configuredDialTimeout returns the dial timeout from the loaded configuration.
+func configuredDialTimeout() time.Duration {
+	return time.Duration(shared.Config.DialTimeoutMs) * time.Millisecond
+}
+
+// upgradeAndCollect performs a TLS handshake over an already negotiated plaintext connection
+// (e.g. after STARTTLS) and collects the peer certificates.
+// Parameters:
+//
+//	conn:     Established plaintext connection
+//	ip:       Target IP address
+//	hostname: Hostname/SNI
+//	port:     Target port
+//
+// Returns: ScanResult or error
+func upgradeAndCollect(conn net.Conn, ip, hostname string, port int) (*ScanResult, error) {
+	// The handshake is recorded to read the key exchange group from the server's messages
+	rc := &recordingConn{Conn: conn}
+	clientAuth := &clientCertRequest{}
+	tlsConn := tls.Client(rc, &tls.Config{
+		ServerName:           hostname,
+		InsecureSkipVerify:   true,
+		GetClientCertificate: clientAuth.getClientCertificate,
+	})
+	start := time.Now()
+	if err := tlsConn.Handshake(); err != nil {
+		if !clientAuth.rejectedAfterRequest(len(tlsConn.ConnectionState().PeerCertificates)) {
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		logutil.DebugLog("Handshake with %s:%d rejected without client certificate: %v", ip, port, err)
+	}
+	latency := time.Since(start)
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no cert returned")
+	}
+
+	var certs []string
+	for _, cert := range state.PeerCertificates {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	fetched, validation := checkChain(state.PeerCertificates, ip, hostname)
+	staple, scts := collectStapledData(state.PeerCertificates, fetched, state.OCSPResponse, state.SignedCertificateTimestamps)
+	for _, cert := range fetched {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	return &ScanResult{
+		IP:                   ip,
+		Port:                 port,
+		Hostname:             hostname,
+		SNI:                  sniValue(hostname),
+		TLSMode:              tlsModeStartTLS,
+		TLSVersion:           tls.VersionName(state.Version),
+		CipherSuite:          tls.CipherSuiteName(state.CipherSuite),
+		ALPN:                 state.NegotiatedProtocol,
+		KeyExchangeGroup:     negotiatedGroup(rc.received.Bytes()),
+		HandshakeLatencyMs:   latency.Milliseconds(),
+		OCSPStaple:           staple,
+		SCTs:                 scts,
+		ClientCertRequested:  clientAuth.requested,
+		AcceptableCAs:        clientAuth.acceptableCAs,
+		Validation:           validation,
+		ChainIncomplete:      len(fetched) > 0,
+		FetchedIntermediates: len(fetched),
+		Certificates:         certs,
+		Timestamp:            time.Now().Unix(),
+	}, nil
+}
+
+// webPorts lists typical HTTPS ports that are scanned as http1 unless a protocol is given.
+var webPorts = map[int]bool{443: true, 8443: true, 4433: true, 5001: true, 10443: true}
+
+// defaultPortProtocols maps well-known ports of non-web services to their protocol.
+// These mappings only apply when no protocol is given (see portProtocol); an explicit
+// protocol, e.g. from an include_list entry, always wins.
+var defaultPortProtocols = map[int]string{
+	21:   "ftp",
+	24:   "lmtp",
+	25:   "smtp",
+	110:  "pop3",
+	119:  "nntp",
+	143:  "imap",
+	389:  "ldap",
+	465:  "smtp",
+	563:  "nntp",
+	587:  "smtp",
+	636:  "ldap",
+	990:  "ftp",
+	993:  "imap",
+	995:  "pop3",
+	3306: "mysql",
+	3389: "rdp",
+	4190: "sieve",
+	5222: "xmpp",
+	5223: "xmpp",
+	5269: "xmpp-server",
+	5432: "postgres",
+	5671: "amqp",
+	6380: "redis",
+	6667: "irc",
+	6697: "irc",
+	8883: "mqtt",
+	9093: "kafka",
+}
+
+// portProtocol returns the protocol to scan port with. An explicit protocol is used as is;
+// otherwise web ports use http1, well-known service ports their defaultPortProtocols entry
+// and all other ports auto-detection.
+func portProtocol(port int, protocol string) string {
+	if protocol != "" {
+		return protocol
+	}
+	if webPorts[port] {
+		return "http1"
+	}
+	if p, ok := defaultPortProtocols[port]; ok {
+		return p
+	}
+	return "auto"
+}
+
 // ProtocolHandler defines a function type for protocol-specific scan logic.
-// Returns true if handled, false to fall back to default TLS scan.
-type ProtocolHandler func(ip, hostname string, port int) (handled bool)
+// Returns the results to send to the webhook, or nil if the scan failed.
+type ProtocolHandler func(ip, hostname string, port int) []ScanResult
 
 // protocolHandlers maps protocol names to their handler functions.
 // Handlers for protocols like smtp, imap, pop3, ldap, and custom can be extended modularly.
-// For http1/h2/h3, the defaultTLSHandler is used to perform ECDSA and RSA handshakes.
+// For http1/h2, the defaultTLSHandler is used to perform ECDSA and RSA handshakes; h3 uses QUIC over UDP.
 var protocolHandlers = map[string]ProtocolHandler{
-	"smtp": smtpProtocolHandler,
-	"imap": func(ip, hostname string, port int) bool {
-		logutil.DebugLog("IMAP protocol handler not implemented for %s:%d", ip, port)
-		return false
+	"smtp":        smtpProtocolHandler,
+	"imap":        imapProtocolHandler,
+	"pop3":        pop3ProtocolHandler,
+	"ldap":        ldapProtocolHandler,
+	"postgres":    postgresProtocolHandler,
+	"mysql":       mysqlProtocolHandler,
+	"ftp":         ftpProtocolHandler,
+	"xmpp":        xmppProtocolHandler,
+	"xmpp-server": xmppServerProtocolHandler,
+	"rdp":         rdpProtocolHandler,
+	"mqtt": func(ip, hostname string, port int) []ScanResult {
+		return defaultTLSHandler(ip, hostname, port, "mqtt")
 	},
-	"pop3": func(ip, hostname string, port int) bool {
-		logutil.DebugLog("POP3 protocol handler not implemented for %s:%d", ip, port)
-		return false
+	"amqp": func(ip, hostname string, port int) []ScanResult {
+		return defaultTLSHandler(ip, hostname, port, "amqp")
 	},
-	"ldap": func(ip, hostname string, port int) bool {
-		logutil.DebugLog("LDAP protocol handler not implemented for %s:%d", ip, port)
-		return false
+	"redis": func(ip, hostname string, port int) []ScanResult {
+		return defaultTLSHandler(ip, hostname, port, "redis")
 	},
-	"custom": func(ip, hostname string, port int) bool {
-		logutil.DebugLog("Custom protocol handler not implemented for %s:%d", ip, port)
-		return false
+	"kafka": func(ip, hostname string, port int) []ScanResult {
+		return defaultTLSHandler(ip, hostname, port, "kafka")
 	},
+	"nntp":   nntpProtocolHandler,
+	"sieve":  sieveProtocolHandler,
+	"irc":    ircProtocolHandler,
+	"lmtp":   lmtpProtocolHandler,
+	"custom": customProtocolHandler,
 	// Default handler for HTTP and other protocols: ECDSA & RSA handshake
-	"http1": func(ip, hostname string, port int) bool {
+	"http1": func(ip, hostname string, port int) []ScanResult {
 		return defaultTLSHandler(ip, hostname, port, "http1")
 	},
-	"h2": func(ip, hostname string, port int) bool {
+	"h2": func(ip, hostname string, port int) []ScanResult {
 		return defaultTLSHandler(ip, hostname, port, "h2")
 	},
-	"h3": func(ip, hostname string, port int) bool {
-		return defaultTLSHandler(ip, hostname, port, "h3")
-	},
+	"h3": h3ProtocolHandler,
 }
 
-// defaultTLSHandler performs ECDSA and RSA handshakes for a given protocol and sends results to the webhook.
-// Used as the default handler for web protocols (http1, h2, h3).
+// defaultTLSHandler performs ECDSA and RSA handshakes for a given protocol and returns the results.
+// Used as the default handler for web protocols (http1, h2) and for implicit TLS ports of other protocols.
 // Parameters:
 //
 //	ip:      Target IP address
@@ -318,62 +668,92 @@ var protocolHandlers = map[string]ProtocolHandler{
 //	port:    Target port
 //	proto:   Protocol string
 //
-// Returns: true (always handles)
-func defaultTLSHandler(ip, hostname string, port int, proto string) bool {
-	webhookURL := shared.Config.WebhookURL
+// Returns: Scan results (nil if no handshake succeeded)
+func defaultTLSHandler(ip, hostname string, port int, proto string) []ScanResult {
+	return collectTLSResults(ip, hostname, port, proto, nil)
+}
+
+// collectTLSResults performs one handshake per configured handshake profile (ECDSA and RSA by default)
+// and server name (see sniNames), optionally after a plaintext preamble, and returns the results
+// with exclude_certs filtering applied.
+// Parameters:
+//
+//	ip:       Target IP address
+//	hostname: Hostname/SNI
+//	port:     Target port
+//	proto:    Protocol string
+//	preamble: Optional plaintext negotiation before each handshake (nil for implicit TLS)
+//
+// Returns: List of ScanResult (empty if no handshake succeeded)
+func collectTLSResults(ip, hostname string, port int, proto string, preamble connPreamble) []ScanResult {
 	dialTimeout := time.Duration(shared.Config.DialTimeoutMs)
 	dialTimeout = dialTimeout * time.Millisecond
 
 	var results []ScanResult
 
-	ecdsaSuites := []uint16{
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
-	}
-	if result, err := tlsHandshakeAndCollectWithTimeout(ip, hostname, port, ecdsaSuites, "ecdsa", proto, dialTimeout); err == nil {
-		results = append(results, *result)
-	} else {
-		logutil.DebugLog("ECDSA handshake failed: %v", err)
-	}
-
-	rsaSuites := []uint16{
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
-		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
-		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-		tls.TLS_RSA_WITH_RC4_128_SHA,
-	}
-	if result, err := tlsHandshakeAndCollectWithTimeout(ip, hostname, port, rsaSuites, "rsa", proto, dialTimeout); err == nil {
-		results = append(results, *result)
-	} else {
-		logutil.DebugLog("RSA handshake failed: %v", err)
-	}
-
-	// Filter certificates based on exclude_certs rules
-	excludeCerts := shared.Config.ExcludeCerts
-	for i := range results {
-		results[i].Certificates = filterCerts(decodeBase64Certs(results[i].Certificates), excludeCerts)
+	var profiles []string
+	for _, handshakeType := range handshakeProfilesFor(ip, hostname, port) {
+		if _, ok := handshakeProfiles[handshakeType]; !ok {
+			logutil.ErrorLog("Unknown handshake profile %s, skipping", handshakeType)
+			continue
+		}
+		profiles = append(profiles, handshakeType)
 	}
 
-	if len(results) > 0 {
-		sendToWebhook(results, webhookURL)
+	// One handshake per server name and profile; handshakes returning the same chain are collapsed into one result
+	chains := make(map[string]int)
+	for _, sni := range sniNames(ip, hostname, port) {
+		for _, handshakeType := range profiles {
+			result, err := tlsHandshakeAndCollectWithTimeout(ip, sni, port, handshakeType, proto, preamble, dialTimeout)
+			if err != nil {
+				logutil.DebugLog("%s handshake (SNI %q) failed: %v", strings.ToUpper(handshakeType), sni, err)
+				continue
+			}
+			result.Hostname = hostname
+			chain := strings.Join(result.Certificates, ",")
+			if i, ok := chains[chain]; ok {
+				if !slices.Contains(results[i].HandshakeTypes, handshakeType) {
+					results[i].HandshakeTypes = append(results[i].HandshakeTypes, handshakeType)
+				}
+				if !slices.Contains(results[i].SNINames, sni) {
+					results[i].SNINames = append(results[i].SNINames, sni)
+				}
+				continue
+			}
+			chains[chain] = len(results)
+			result.HandshakeTypes = []string{handshakeType}
+			result.SNINames = []string{sni}
+			results = append(results, *result)
+		}
 	}
-	return true
+
+	// Probe the accepted TLS versions once per endpoint
+	if len(results) > 0 && tlsVersionEnumerationEnabled(ip, hostname, port) {
+		versions := enumerateTLSVersions(ip, hostname, port, preamble)
+		for i := range results {
+			results[i].SupportedTLSVersions = versions
+		}
+	}
+	if len(results) > 0 && cipherEnumerationEnabled(ip, hostname, port) {
+		suites := enumerateCipherSuites(ip, hostname, port, preamble)
+		for i := range results {
+			results[i].CipherSuites = suites
+		}
+	}
+	if len(results) > 0 && keyExchangeDetectionEnabled(ip, hostname, port) {
+		if info, err := detectKeyExchange(ip, hostname, port, preamble); err == nil {
+			for i := range results {
+				results[i].KeyExchange = info
+			}
+		} else {
+			logutil.DebugLog("Key exchange detection failed for %s:%d: %v", ip, port, err)
+		}
+	}
+
+	// Check revocation on the complete chain, then filter certificates based on exclude_certs rules
+	annotateRevocation(results)
+	filterResultCerts(results)
+	return results
 }
 
 // ScanAndSendWithProtocol scans each port using the specified protocol, supporting concurrency and protocol handlers.
@@ -383,34 +763,21 @@ func defaultTLSHandler(ip, hostname string, port int, proto string) bool {
 //	ip:       Target IP address
 //	hostname: Hostname/SNI
 //	ports:    List of ports to scan
-//	protocol: Protocol string (e.g., "http1", "smtp")
+//	protocol: Protocol string (e.g., "http1", "smtp"); empty selects it per port (see portProtocol)
 func ScanAndSendWithProtocol(ip, hostname string, ports []int, protocol string) {
-	var results []ScanResult
 	webhookURL := shared.Config.WebhookURL
 
-	webPorts := map[int]bool{443: true, 8443: true, 4433: true, 5001: true, 10443: true}
-	smtpPorts := map[int]bool{25: true, 465: true, 587: true}
-
 	concurrency := shared.Config.ConcurrencyLimit
 	sem := make(chan struct{}, concurrency)
 	var wg sync.WaitGroup
 
-	// Use configured dial timeout
-	dialTimeout := time.Duration(shared.Config.DialTimeoutMs)
-	dialTimeout = dialTimeout * time.Millisecond
-
 	for _, port := range ports {
 		wg.Add(1)
 		sem <- struct{}{}
 		go func(port int) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			proto := protocol
-			if proto == "" && webPorts[port] {
-				proto = "http1"
-			} else if smtpPorts[port] {
-				proto = "smtp"
-			}
+			proto := portProtocol(port, protocol)
 
 			// check if proto is allowed (check if in AllowedProtocols)
 			if proto != "" && !shared.Contains(AllowedProtocols, proto) {
@@ -418,38 +785,50 @@ func ScanAndSendWithProtocol(ip, hostname string, ports []int, protocol string)
 				return
 			}
 
-			// Protocol handler abstraction
-			if handler, ok := protocolHandlers[proto]; ok {
-				logutil.DebugLog("Scanning %s -> %s:%d (protocol: %s)", ip, hostname, port, proto)
-				if handled := handler(ip, hostname, port); handled {
+			// Auto-detection resolves the protocol before dispatching to its handler
+			var detected string
+			if proto == "auto" {
+				var err error
+				detected, err = detectProtocol(ip, hostname, port)
+				if err != nil {
+					logutil.DebugLog("Protocol detection failed for %s:%d: %v", ip, port, err)
 					return
 				}
-			} else {
-				logutil.ErrorLog("No handler for protocol %s, falling back to default TLS scan", proto)
+				if detected == "" {
+					logutil.DebugLog("No protocol detected for %s:%d", ip, port)
+					return
+				}
+				logutil.DebugLog("Detected protocol %s for %s:%d", detected, ip, port)
+				proto = handlerProtocol(detected)
+			}
+
+			// Protocol handler abstraction
+			handler, ok := protocolHandlers[proto]
+			if !ok {
+				logutil.ErrorLog("No handler for protocol %s", proto)
+				return
+			}
+			logutil.DebugLog("Scanning %s -> %s:%d (protocol: %s)", ip, hostname, port, proto)
+			portResults := handler(ip, hostname, port)
+			if len(portResults) == 0 {
+				return
 			}
+			for i := range portResults {
+				portResults[i].DetectedProtocol = detected
+			}
+			sendToWebhook(portResults, webhookURL)
 		}(port)
 	}
 	wg.Wait()
-
-	if len(results) > 0 {
-		sendToWebhook(results, webhookURL)
-	}
 }
 
 // ScanAndSend is a compatibility helper for legacy code paths.
 // It loops over the given ports, determines the protocol for each port,
 // and calls ScanAndSendWithProtocol for each port/protocol combination.
+// Ports that are neither web ports nor well-known service ports are auto-detected.
 func ScanAndSend(ip, host string, ports []int) {
-	webPorts := map[int]string{443: "http1", 8443: "http1", 4433: "http1", 10443: "http1", 5001: "http1"}
-	smtpPorts := map[int]string{25: "smtp", 465: "smtp", 587: "smtp"}
 	for _, port := range ports {
-		proto := "http1" // default protocol is http1
-		if p, ok := webPorts[port]; ok {
-			proto = p
-		} else if p, ok := smtpPorts[port]; ok {
-			proto = p
-		}
-		ScanAndSendWithProtocol(ip, host, []int{port}, proto)
+		ScanAndSendWithProtocol(ip, host, []int{port}, portProtocol(port, ""))
 	}
 }
 
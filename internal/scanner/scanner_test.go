@@ -0,0 +1,25 @@
+package scanner
+
+import "testing"
+
+func TestPortProtocol(t *testing.T) {
+	tests := []struct {
+		port     int
+		protocol string
+		want     string
+	}{
+		{443, "", "http1"},
+		{25, "", "smtp"},
+		{3389, "", "rdp"},
+		{12345, "", "auto"},
+		// An explicit protocol wins over the port mappings
+		{25, "custom", "custom"},
+		{443, "h2", "h2"},
+		{993, "http1", "http1"},
+	}
+	for _, tt := range tests {
+		if got := portProtocol(tt.port, tt.protocol); got != tt.want {
+			t.Errorf("portProtocol(%d, %q) = %q, want %q", tt.port, tt.protocol, got, tt.want)
+		}
+	}
+}
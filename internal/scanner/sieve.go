@@ -0,0 +1,60 @@
+// sieve.go provides the ManageSieve STARTTLS scan logic and protocol handler for NextPKI.
+// It implements certificate extraction for ManageSieve services (RFC 5804, port 4190).
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isSieveResponse reports whether line is a ManageSieve status response (OK, NO or BYE).
+func isSieveResponse(line string) bool {
+	upper := strings.ToUpper(line)
+	return strings.HasPrefix(upper, "OK") || strings.HasPrefix(upper, "NO") || strings.HasPrefix(upper, "BYE")
+}
+
+// scanSieveStartTLS connects to a ManageSieve server, checks the capability greeting for
+// STARTTLS, upgrades to TLS and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanSieveStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	// The greeting is the capability list, terminated by a status response
+	lines, err := s.readReply(isSieveResponse)
+	if err != nil {
+		return nil, fmt.Errorf("sieve greeting failed: %w", err)
+	}
+	if !strings.HasPrefix(strings.ToUpper(lines[len(lines)-1]), "OK") {
+		return nil, fmt.Errorf("unexpected sieve greeting: %q", lines[len(lines)-1])
+	}
+	supportsStartTLS := false
+	for _, l := range lines[:len(lines)-1] {
+		if strings.EqualFold(strings.TrimSpace(l), `"STARTTLS"`) {
+			supportsStartTLS = true
+			break
+		}
+	}
+	if !supportsStartTLS {
+		return nil, fmt.Errorf("STARTTLS not supported on %s", ip)
+	}
+
+	reply, err := s.command("STARTTLS", isSieveResponse)
+	if err != nil || !strings.HasPrefix(strings.ToUpper(reply[len(reply)-1]), "OK") {
+		return nil, fmt.Errorf("STARTTLS failed: %v %v", reply, err)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
+}
+
+// sieveProtocolHandler is a ProtocolHandler for ManageSieve STARTTLS scanning.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func sieveProtocolHandler(ip, hostname string, port int) []ScanResult {
+	return startTLSResults("ManageSieve", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanSieveStartTLS(ip, sni, port)
+	})
+}
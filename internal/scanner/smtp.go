@@ -1,108 +1,151 @@
-// smtp.go provides the SMTP STARTTLS scan logic and protocol handler for NextPKI.
-// It implements certificate extraction for SMTP services supporting STARTTLS.
-// smtp.go: SMTP STARTTLS scan logic and handler
+// smtp.go provides the SMTP and LMTP STARTTLS scan logic and protocol handlers for NextPKI.
+// It implements certificate extraction for SMTP services supporting STARTTLS and
+// implicit TLS (SMTPS, port 465), and for LMTP services supporting STARTTLS.
 package scanner
 
 import (
-	"bufio"
-	"crypto/tls"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
 	"github.com/nextpki/certscan/internal/logutil"
-	"github.com/nextpki/certscan/internal/shared"
 )
 
-// scanSMTPStartTLS connects to an SMTP server, upgrades to TLS using STARTTLS, and extracts certificates.
-// Returns a ScanResult with certificate data or an error.
-func scanSMTPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
-	address := fmt.Sprintf("%s:%d", ip, port)
-	conn, err := net.DialTimeout("tcp", address, time.Second)
+// smtpsPort is the well-known port for SMTP over implicit TLS (RFC 8314).
+const smtpsPort = 465
+
+// smtpBannerTimeout is how long to wait for the 220 banner on non-standard ports before
+// assuming the server is waiting for a TLS ClientHello instead.
+const smtpBannerTimeout = 3 * time.Second
+
+// smtpPlaintextPorts are the ports where SMTP always starts in plaintext (MTA relay and
+// submission). Greylisting and postscreen delay the banner there by 5-30 seconds, so the
+// scanner waits for the full starttlsIOTimeout before trying implicit TLS.
+var smtpPlaintextPorts = map[int]bool{25: true, 587: true}
+
+// errSMTPImplicitTLS is returned by scanSMTPStartTLS when the server does not send a
+// plaintext banner, which indicates that it expects implicit TLS.
+var errSMTPImplicitTLS = errors.New("no smtp banner, server expects implicit TLS")
+
+// smtpBannerWait returns how long to wait for the banner on port.
+func smtpBannerWait(port int) time.Duration {
+	if smtpPlaintextPorts[port] {
+		return starttlsIOTimeout
+	}
+	return smtpBannerTimeout
+}
+
+// smtpStartTLS runs the SMTP-style STARTTLS dialogue on an open session: greeting,
+// hello (EHLO for SMTP, LHLO for LMTP), capability check and STARTTLS.
+func smtpStartTLS(s *lineSession, ip, hello string) error {
+	greeting, err := s.readReply(isFinalReplyLine)
 	if err != nil {
-		return nil, fmt.Errorf("tcp dial failed: %w", err)
+		return fmt.Errorf("smtp greeting failed: %w", err)
+	}
+	if !strings.HasPrefix(greeting[len(greeting)-1], "220") {
+		return fmt.Errorf("unexpected smtp greeting: %q", greeting[len(greeting)-1])
 	}
-	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
-	_, err = reader.ReadString('\n') // read greeting
+	lines, err := s.command(hello+" certscan", isFinalReplyLine)
 	if err != nil {
-		return nil, fmt.Errorf("smtp greeting failed: %w", err)
+		return fmt.Errorf("%s read failed: %w", hello, err)
+	}
+	if !containsCapability(lines, "STARTTLS") {
+		return fmt.Errorf("STARTTLS not supported on %s", ip)
 	}
 
-	fmt.Fprintf(conn, "EHLO certscan\r\n")
-	lines := []string{}
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, fmt.Errorf("EHLO read failed: %w", err)
-		}
-		lines = append(lines, line)
-		if !strings.HasPrefix(line, "250-") {
-			break
-		}
+	reply, err := s.command("STARTTLS", isFinalReplyLine)
+	if err != nil || !strings.HasPrefix(reply[len(reply)-1], "220") {
+		return fmt.Errorf("STARTTLS failed: %v %v", reply, err)
+	}
+	return nil
+}
+
+// scanSMTPStartTLS connects to an SMTP server, upgrades to TLS using STARTTLS, and extracts certificates.
+// Returns a ScanResult with certificate data or an error. If the server stays silent (see
+// smtpBannerWait) or answers with a TLS record instead of a banner, errSMTPImplicitTLS is returned.
+func scanSMTPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
 	}
+	defer s.Close()
 
-	supportsStartTLS := false
-	for _, l := range lines {
-		if strings.Contains(strings.ToUpper(l), "STARTTLS") {
-			supportsStartTLS = true
-			break
+	// Sniff the first byte: a plaintext server greets with "220", an implicit TLS
+	// server waits for our ClientHello or sends a TLS alert/handshake record (0x15/0x16)
+	s.conn.SetReadDeadline(time.Now().Add(smtpBannerWait(port)))
+	first, err := s.reader.Peek(1)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, errSMTPImplicitTLS
 		}
+		return nil, fmt.Errorf("smtp greeting failed: %w", err)
 	}
-	if !supportsStartTLS {
-		return nil, fmt.Errorf("STARTTLS not supported on %s", ip)
+	if first[0] == 0x15 || first[0] == 0x16 {
+		return nil, errSMTPImplicitTLS
 	}
+	s.conn.SetDeadline(time.Now().Add(starttlsIOTimeout))
 
-	fmt.Fprintf(conn, "STARTTLS\r\n")
-	line, err := reader.ReadString('\n')
-	if err != nil || !strings.HasPrefix(line, "220") {
-		return nil, fmt.Errorf("STARTTLS failed: %v", line)
+	if err := smtpStartTLS(s, ip, "EHLO"); err != nil {
+		return nil, err
 	}
 
 	// Upgrade connection
-	tlsConn := tls.Client(conn, &tls.Config{
-		ServerName:         hostname,
-		InsecureSkipVerify: true,
-	})
-	err = tlsConn.Handshake()
+	return s.upgrade(ip, hostname, port)
+}
+
+// scanLMTPStartTLS connects to an LMTP server (RFC 2033), upgrades to TLS using STARTTLS
+// after LHLO, and extracts certificates.
+// Returns a ScanResult with certificate data or an error.
+func scanLMTPStartTLS(ip, hostname string, port int) (*ScanResult, error) {
+	s, err := dialLineSession(ip, port)
 	if err != nil {
-		return nil, fmt.Errorf("TLS handshake failed: %w", err)
-	}
-	state := tlsConn.ConnectionState()
-	if len(state.PeerCertificates) == 0 {
-		return nil, fmt.Errorf("no cert returned")
+		return nil, err
 	}
+	defer s.Close()
 
-	var certs []string
-	for _, cert := range state.PeerCertificates {
-		certs = append(certs, base64.StdEncoding.EncodeToString(cert.Raw))
+	if err := smtpStartTLS(s, ip, "LHLO"); err != nil {
+		return nil, err
 	}
 
-	return &ScanResult{
-		IP:           ip,
-		Port:         port,
-		Hostname:     hostname,
-		Certificates: certs,
-		Timestamp:    time.Now().Unix(),
-	}, nil
+	// Upgrade connection
+	return s.upgrade(ip, hostname, port)
 }
 
-// smtpProtocolHandler is a ProtocolHandler for SMTP STARTTLS scanning.
-// It sends results to the webhook and returns true if handled.
-func smtpProtocolHandler(ip, hostname string, port int) bool {
-	result, err := scanSMTPStartTLS(ip, hostname, port)
+// smtpProtocolHandler is a ProtocolHandler for SMTP scanning.
+// Port 465 is scanned with a direct TLS handshake; on all other ports the banner decides
+// between STARTTLS and implicit TLS. Results of the implicit TLS fallback are marked with
+// TLSModeFallback.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func smtpProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == smtpsPort {
+		return defaultTLSHandler(ip, hostname, port, "smtp")
+	}
+	results, err := collectStartTLSResults("SMTP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanSMTPStartTLS(ip, sni, port)
+	})
+	if errors.Is(err, errSMTPImplicitTLS) {
+		logutil.DebugLog("No SMTP banner from %s:%d, falling back to implicit TLS", ip, port)
+		results := collectTLSResults(ip, hostname, port, "smtp", nil)
+		for i := range results {
+			results[i].TLSModeFallback = true
+		}
+		return results
+	}
 	if err != nil {
 		logutil.DebugLog("STARTTLS scan failed: %v", err)
-		return true // handled, but failed
+		return nil
 	}
-	logutil.DebugLog("STARTTLS scan successful for %s:%d", ip, port)
-	logutil.DebugLog("Certificate for %s:%d: %+v", ip, port, result)
-	// Filter certificates before sending to webhook
-	excludeCerts := shared.Config.ExcludeCerts
-	result.Certificates = filterCerts(decodeBase64Certs(result.Certificates), excludeCerts)
-	sendToWebhook([]ScanResult{*result}, shared.Config.WebhookURL)
-	return true
+	return results
+}
+
+// lmtpProtocolHandler is a ProtocolHandler for LMTP STARTTLS scanning.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func lmtpProtocolHandler(ip, hostname string, port int) []ScanResult {
+	return startTLSResults("LMTP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanLMTPStartTLS(ip, sni, port)
+	})
 }
@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer greets after bannerDelay and answers EHLO and STARTTLS before starting TLS with cfg.
+func fakeSMTPServer(t *testing.T, cfg *tls.Config, bannerDelay time.Duration) (string, int) {
+	return listenTCP(t, func(conn net.Conn) {
+		time.Sleep(bannerDelay)
+		fmt.Fprint(conn, "220-mail.scanner.test ESMTP\r\n220 ready\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "EHLO":
+				fmt.Fprint(conn, "250-mail.scanner.test\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+			case "STARTTLS":
+				fmt.Fprint(conn, "220 2.0.0 Ready to start TLS\r\n")
+				serveTLS(conn, cfg)
+				return
+			default:
+				fmt.Fprint(conn, "502 unknown command\r\n")
+			}
+		}
+	})
+}
+
+func TestScanSMTPStartTLS(t *testing.T) {
+	useTestConfig(t)
+	ip, port := fakeSMTPServer(t, testTLSConfig(t), 0)
+	result, err := scanSMTPStartTLS(ip, testHostname, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLSMode != tlsModeStartTLS || len(result.Certificates) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestScanSMTPStartTLSDelayedBanner(t *testing.T) {
+	useTestConfig(t)
+	ip, port := fakeSMTPServer(t, testTLSConfig(t), smtpBannerTimeout+500*time.Millisecond)
+
+	// Treat the test port like port 25, where a greylisting delay is not implicit TLS
+	smtpPlaintextPorts[port] = true
+	t.Cleanup(func() { delete(smtpPlaintextPorts, port) })
+
+	result, err := scanSMTPStartTLS(ip, testHostname, port)
+	if err != nil {
+		t.Fatalf("delayed banner treated as failure: %v", err)
+	}
+	if result.TLSMode != tlsModeStartTLS {
+		t.Fatalf("got tls_mode %q, want %q", result.TLSMode, tlsModeStartTLS)
+	}
+}
+
+func TestScanSMTPStartTLSImplicitTLS(t *testing.T) {
+	useTestConfig(t)
+	// A TLS alert record instead of a banner means the server expects implicit TLS
+	ip, port := listenTCP(t, func(conn net.Conn) {
+		conn.Write([]byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x28})
+	})
+	if _, err := scanSMTPStartTLS(ip, testHostname, port); !errors.Is(err, errSMTPImplicitTLS) {
+		t.Fatalf("got %v, want errSMTPImplicitTLS", err)
+	}
+}
+
+func TestSMTPProtocolHandlerMultiSNI(t *testing.T) {
+	cfg := useTestConfig(t)
+	cfg.MultiSNI = true
+	// The server returns a default certificate unless the hostname is sent as SNI
+	named, fallback := testCertificate(t), testCertificate(t)
+	ip, port := fakeSMTPServer(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == testHostname {
+				return &named, nil
+			}
+			return &fallback, nil
+		},
+	}, 0)
+
+	results := smtpProtocolHandler(ip, testHostname, port)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per distinct chain: %+v", len(results), results)
+	}
+	if results[0].SNI != testHostname || results[1].SNI != "" {
+		t.Fatalf("got SNIs %q and %q", results[0].SNI, results[1].SNI)
+	}
+	for _, result := range results {
+		if result.Hostname != testHostname || result.TLSMode != tlsModeStartTLS || len(result.SNINames) == 0 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func TestSMTPBannerWait(t *testing.T) {
+	for port, want := range map[int]time.Duration{
+		25:   starttlsIOTimeout,
+		587:  starttlsIOTimeout,
+		2525: smtpBannerTimeout,
+	} {
+		if got := smtpBannerWait(port); got != want {
+			t.Errorf("port %d: got %v, want %v", port, got, want)
+		}
+	}
+}
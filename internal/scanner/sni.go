@@ -0,0 +1,71 @@
+// sni.go provides multi-SNI probing for NextPKI.
+// Besides the target hostname, endpoints can be probed without SNI, with the PTR names of
+// their IP and with per-entry candidate names. Load balancers and virtual hosts often return
+// a different (default) certificate for each, which a single-SNI scan never sees.
+package scanner
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+)
+
+// multiSNIEnabled reports whether multi-SNI probing is enabled globally or for the
+// include_list entry matching the target.
+func multiSNIEnabled(ip, hostname string, port int) bool {
+	if shared.Config.MultiSNI {
+		return true
+	}
+	entry := includeEntryFor(ip, hostname, port)
+	return entry != nil && entry.MultiSNI
+}
+
+// sniValue returns the server name actually sent for name. IP addresses are not permitted
+// as SNI (RFC 6066, 3), so they result in no SNI, like an empty name.
+func sniValue(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if net.ParseIP(name) != nil {
+		return ""
+	}
+	return name
+}
+
+// ptrNames returns the reverse DNS names of ip, bounded by the dial timeout.
+func ptrNames(ip string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), configuredDialTimeout())
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		logutil.DebugLog("PTR lookup for %s failed: %v", ip, err)
+		return nil
+	}
+	return names
+}
+
+// sniNames returns the distinct server names to probe a target with: the hostname first,
+// then, if multi-SNI probing is enabled, no SNI and the PTR names, followed by the
+// sni_candidates of the matching include_list entry. An empty string means no SNI.
+func sniNames(ip, hostname string, port int) []string {
+	candidates := []string{hostname}
+	if multiSNIEnabled(ip, hostname, port) {
+		candidates = append(candidates, "")
+		candidates = append(candidates, ptrNames(ip)...)
+	}
+	if entry := includeEntryFor(ip, hostname, port); entry != nil {
+		candidates = append(candidates, entry.SNICandidates...)
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		name := sniValue(candidate)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
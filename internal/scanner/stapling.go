@@ -0,0 +1,185 @@
+// stapling.go provides OCSP stapling and Certificate Transparency capture for NextPKI.
+// The stapled OCSP response is parsed into its status and validity window, and the SCTs
+// delivered in the TLS handshake, in the OCSP staple and embedded in the leaf certificate
+// are collected, so stale staples and certificates missing CT can be spotted.
+package scanner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"slices"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OIDs of the SignedCertificateTimestampList extension in certificates and OCSP responses (RFC 6962, 3.3).
+var (
+	oidCertificateSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidOCSPSCTList        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// SCT sources reported in SCTInfo.Source.
+const (
+	sctSourceTLS         = "tls"
+	sctSourceOCSP        = "ocsp"
+	sctSourceCertificate = "certificate"
+)
+
+// OCSPStapleInfo describes the OCSP response stapled by the server.
+type OCSPStapleInfo struct {
+	Status     string `json:"status"`                // good, revoked, unknown, unverified (no issuer to verify against) or invalid
+	ThisUpdate int64  `json:"this_update,omitempty"` // Unix timestamp the response was produced for
+	NextUpdate int64  `json:"next_update,omitempty"` // Optional: Unix timestamp the response expires
+	RevokedAt  int64  `json:"revoked_at,omitempty"`  // Optional: Unix timestamp of the revocation
+	Stale      bool   `json:"stale"`                 // Whether next_update has passed at scan time
+	Error      string `json:"error,omitempty"`       // Optional: why the response could not be parsed or verified
+}
+
+// SCTInfo describes a Signed Certificate Timestamp.
+type SCTInfo struct {
+	Source    string `json:"source"`    // Where the SCT was delivered: tls, ocsp or certificate
+	Version   int    `json:"version"`   // SCT version (0 for v1)
+	LogID     string `json:"log_id"`    // Base64-encoded ID of the issuing CT log
+	Timestamp int64  `json:"timestamp"` // Unix timestamp in milliseconds the log issued the SCT
+}
+
+// ocspStatusNames maps OCSP certificate statuses to their reported names.
+var ocspStatusNames = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// parseSCT parses a single serialized SCT (RFC 6962, 3.2).
+func parseSCT(raw []byte, source string) (SCTInfo, bool) {
+	s := cryptobyte.String(raw)
+	var version uint8
+	var logID []byte
+	var timestamp uint64
+	if !s.ReadUint8(&version) || !s.ReadBytes(&logID, 32) || !s.ReadUint64(&timestamp) {
+		return SCTInfo{}, false
+	}
+	return SCTInfo{
+		Source:    source,
+		Version:   int(version),
+		LogID:     base64.StdEncoding.EncodeToString(logID),
+		Timestamp: int64(timestamp),
+	}, true
+}
+
+// parseSCTList parses a SignedCertificateTimestampList extension value, which wraps the
+// TLS-encoded list in an ASN.1 OCTET STRING.
+func parseSCTList(extValue []byte, source string) []SCTInfo {
+	var list []byte
+	if rest, err := asn1.Unmarshal(extValue, &list); err != nil || len(rest) > 0 {
+		return nil
+	}
+	s := cryptobyte.String(list)
+	var entries cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&entries) {
+		return nil
+	}
+	var scts []SCTInfo
+	for !entries.Empty() {
+		var entry cryptobyte.String
+		if !entries.ReadUint16LengthPrefixed(&entry) {
+			break
+		}
+		if sct, ok := parseSCT(entry, source); ok {
+			scts = append(scts, sct)
+		}
+	}
+	return scts
+}
+
+// sctsFromExtensions collects the SCTs of the SignedCertificateTimestampList extension with the given OID.
+func sctsFromExtensions(extensions []pkix.Extension, oid asn1.ObjectIdentifier, source string) []SCTInfo {
+	for _, ext := range extensions {
+		if ext.Id.Equal(oid) {
+			return parseSCTList(ext.Value, source)
+		}
+	}
+	return nil
+}
+
+// stapleIssuer returns the certificate that issued leaf among the presented chain and the
+// fetched intermediates, or nil if the issuer is not available.
+func stapleIssuer(leaf *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, cert := range candidates {
+		if bytes.Equal(cert.RawSubject, leaf.RawIssuer) && leaf.CheckSignatureFrom(cert) == nil {
+			return cert
+		}
+	}
+	return nil
+}
+
+// collectStapledData parses the stapled OCSP response and collects the SCTs delivered in the
+// TLS handshake, in the OCSP response and embedded in the leaf certificate. The response is
+// verified against the leaf's issuer; without an issuer it is reported as unverified.
+// Parameters:
+//
+//	chain:        Certificates presented by the server, leaf first (may be empty)
+//	fetched:      Intermediates fetched via AIA, searched for the issuer as well
+//	ocspResponse: Stapled OCSP response (may be empty)
+//	tlsSCTs:      SCTs from the signed_certificate_timestamp extension
+//
+// Returns: OCSP staple information (nil if none was stapled) and all SCTs
+func collectStapledData(chain, fetched []*x509.Certificate, ocspResponse []byte, tlsSCTs [][]byte) (*OCSPStapleInfo, []SCTInfo) {
+	var leaf *x509.Certificate
+	if len(chain) > 0 {
+		leaf = chain[0]
+	}
+	var scts []SCTInfo
+	for _, raw := range tlsSCTs {
+		if sct, ok := parseSCT(raw, sctSourceTLS); ok {
+			scts = append(scts, sct)
+		}
+	}
+
+	var staple *OCSPStapleInfo
+	if len(ocspResponse) > 0 {
+		staple = &OCSPStapleInfo{}
+		var issuer *x509.Certificate
+		if leaf != nil {
+			issuer = stapleIssuer(leaf, append(slices.Clip(chain[1:]), fetched...))
+		}
+		var resp *ocsp.Response
+		var err error
+		if issuer != nil {
+			// Checks the serial number and the signature of the issuer or its delegated responder
+			resp, err = ocsp.ParseResponseForCert(ocspResponse, leaf, issuer)
+		} else {
+			resp, err = ocsp.ParseResponse(ocspResponse, nil)
+		}
+		if err != nil {
+			logutil.DebugLog("Failed to parse stapled OCSP response: %v", err)
+			staple.Status = "invalid"
+			staple.Error = err.Error()
+		} else {
+			staple.Status = ocspStatusNames[resp.Status]
+			if issuer == nil {
+				staple.Status = "unverified"
+			}
+			staple.ThisUpdate = resp.ThisUpdate.Unix()
+			if !resp.NextUpdate.IsZero() {
+				staple.NextUpdate = resp.NextUpdate.Unix()
+				staple.Stale = resp.NextUpdate.Before(time.Now())
+			}
+			if resp.Status == ocsp.Revoked {
+				staple.RevokedAt = resp.RevokedAt.Unix()
+			}
+			scts = append(scts, sctsFromExtensions(resp.Extensions, oidOCSPSCTList, sctSourceOCSP)...)
+		}
+	}
+
+	if leaf != nil {
+		scts = append(scts, sctsFromExtensions(leaf.Extensions, oidCertificateSCTList, sctSourceCertificate)...)
+	}
+	return staple, scts
+}
@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponse signs an OCSP response for cert with the issuer's key.
+func (ca *testIssuer) ocspResponse(t *testing.T, cert *x509.Certificate, status int) []byte {
+	t.Helper()
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+	}
+	der, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCollectStapledDataVerification(t *testing.T) {
+	ca := newTestIssuer(t, "Staple CA")
+	other := newTestIssuer(t, "Other CA")
+	leaf := ca.issue(t, &x509.Certificate{})
+	otherSerial := ca.issue(t, &x509.Certificate{SerialNumber: big.NewInt(99)})
+
+	tests := []struct {
+		name       string
+		chain      []*x509.Certificate
+		fetched    []*x509.Certificate
+		response   []byte
+		wantStatus string
+	}{
+		{"good", []*x509.Certificate{leaf, ca.cert}, nil, ca.ocspResponse(t, leaf, ocsp.Good), "good"},
+		{"revoked", []*x509.Certificate{leaf, ca.cert}, nil, ca.ocspResponse(t, leaf, ocsp.Revoked), "revoked"},
+		{"issuer fetched via AIA", []*x509.Certificate{leaf}, []*x509.Certificate{ca.cert}, ca.ocspResponse(t, leaf, ocsp.Good), "good"},
+		{"no issuer", []*x509.Certificate{leaf}, nil, ca.ocspResponse(t, leaf, ocsp.Good), "unverified"},
+		{"wrong issuer in chain", []*x509.Certificate{leaf, other.cert}, nil, ca.ocspResponse(t, leaf, ocsp.Good), "unverified"},
+		{"signed by another CA", []*x509.Certificate{leaf, ca.cert}, nil, other.ocspResponse(t, leaf, ocsp.Good), "invalid"},
+		{"response for another certificate", []*x509.Certificate{leaf, ca.cert}, nil, ca.ocspResponse(t, otherSerial, ocsp.Good), "invalid"},
+		{"unparseable", []*x509.Certificate{leaf, ca.cert}, nil, []byte{0x30, 0x00}, "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			staple, _ := collectStapledData(tt.chain, tt.fetched, tt.response, nil)
+			if staple == nil || staple.Status != tt.wantStatus {
+				t.Fatalf("got %+v, want status %q", staple, tt.wantStatus)
+			}
+			if tt.wantStatus == "revoked" && staple.RevokedAt == 0 {
+				t.Fatal("revoked_at missing")
+			}
+		})
+	}
+
+	if staple, _ := collectStapledData([]*x509.Certificate{leaf, ca.cert}, nil, nil, nil); staple != nil {
+		t.Fatalf("got %+v without a stapled response", staple)
+	}
+}
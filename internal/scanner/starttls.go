@@ -0,0 +1,159 @@
+// starttls.go provides the shared line-oriented STARTTLS helper for NextPKI.
+// Text protocols (SMTP, LMTP, IMAP, POP3, FTP, NNTP, ManageSieve, IRC) use a lineSession to run their
+// plaintext dialogue and then upgrade the same connection with upgradeAndCollect.
+// The results of all STARTTLS protocols are collected once per server name (see sni.go).
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+)
+
+// lineSession is a plaintext connection used for a line-oriented STARTTLS dialogue.
+type lineSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialLineSession connects to ip:port with the configured dial timeout and bounds
+// the whole dialogue by starttlsIOTimeout.
+func dialLineSession(ip string, port int) (*lineSession, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, configuredDialTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("tcp dial failed: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(starttlsIOTimeout))
+	return &lineSession{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Close closes the underlying connection.
+func (s *lineSession) Close() error {
+	return s.conn.Close()
+}
+
+// send writes a single command line terminated by CRLF.
+func (s *lineSession) send(line string) error {
+	_, err := fmt.Fprintf(s.conn, "%s\r\n", line)
+	return err
+}
+
+// readLine reads a single line without its line terminator.
+func (s *lineSession) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readReply reads lines until last reports the final line of the reply.
+// Returns all lines of the reply, including the final one.
+func (s *lineSession) readReply(last func(line string) bool) ([]string, error) {
+	var lines []string
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		if last(line) {
+			return lines, nil
+		}
+	}
+}
+
+// command sends a command line and reads the reply until last reports the final line.
+func (s *lineSession) command(line string, last func(line string) bool) ([]string, error) {
+	if err := s.send(line); err != nil {
+		return nil, err
+	}
+	return s.readReply(last)
+}
+
+// request sends a command line and reads a single-line reply.
+func (s *lineSession) request(line string) (string, error) {
+	if err := s.send(line); err != nil {
+		return "", err
+	}
+	return s.readLine()
+}
+
+// upgrade performs the TLS handshake on the negotiated connection and collects certificates.
+func (s *lineSession) upgrade(ip, hostname string, port int) (*ScanResult, error) {
+	return upgradeAndCollect(s.conn, ip, hostname, port)
+}
+
+// isFinalReplyLine reports whether line ends an SMTP-style reply, where continuation
+// lines carry a '-' after the three-digit code (SMTP, LMTP, FTP).
+func isFinalReplyLine(line string) bool {
+	return len(line) < 4 || line[3] != '-'
+}
+
+// isDotTerminator reports whether line ends a dot-terminated multi-line block (NNTP, POP3).
+func isDotTerminator(line string) bool {
+	return line == "."
+}
+
+// containsCapability reports whether any line announces the given capability keyword.
+func containsCapability(lines []string, capability string) bool {
+	for _, l := range lines {
+		if strings.Contains(strings.ToUpper(l), capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// startTLSScan runs a complete STARTTLS dialogue and upgrade, sending sni as server name.
+type startTLSScan func(sni string) (*ScanResult, error)
+
+// collectStartTLSResults runs scan once per server name from sniNames, as a connection can only
+// be upgraded once, and returns the distinct chains with their certificates filtered for sending
+// to the webhook. Chains returned for several names are collapsed into one result, as in
+// collectTLSResults. Returns the error of the first name (the hostname) if that scan failed.
+func collectStartTLSResults(name, ip, hostname string, port int, scan startTLSScan) ([]ScanResult, error) {
+	var results []ScanResult
+	chains := make(map[string]int)
+	for i, sni := range sniNames(ip, hostname, port) {
+		result, err := scan(sni)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			logutil.DebugLog("%s STARTTLS scan (SNI %q) failed: %v", name, sni, err)
+			continue
+		}
+		logutil.DebugLog("%s STARTTLS scan (SNI %q) successful for %s:%d", name, sni, ip, port)
+		result.Hostname = hostname
+		chain := strings.Join(result.Certificates, ",")
+		if j, ok := chains[chain]; ok {
+			results[j].SNINames = append(results[j].SNINames, sni)
+			continue
+		}
+		chains[chain] = len(results)
+		result.SNINames = []string{sni}
+		results = append(results, *result)
+	}
+	// Check revocation on the complete chain, then filter certificates based on exclude_certs rules
+	annotateRevocation(results)
+	filterResultCerts(results)
+	return results, nil
+}
+
+// startTLSResults runs a STARTTLS scan for each server name like collectStartTLSResults.
+// Returns nil if the scan failed.
+func startTLSResults(name, ip, hostname string, port int, scan startTLSScan) []ScanResult {
+	results, err := collectStartTLSResults(name, ip, hostname, port, scan)
+	if err != nil {
+		logutil.DebugLog("%s STARTTLS scan failed: %v", name, err)
+		return nil
+	}
+	return results
+}
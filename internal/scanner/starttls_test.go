@@ -0,0 +1,262 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeLineServer sends greeting, answers each command line with the reply for its first word
+// and starts TLS after replying to upgrade.
+func fakeLineServer(t *testing.T, greeting string, replies map[string]string, upgrade string) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		fmt.Fprint(conn, greeting)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			command := strings.ToUpper(fields[0])
+			reply, ok := replies[command]
+			if !ok {
+				reply = "500 unknown command\r\n"
+			}
+			fmt.Fprint(conn, reply)
+			if command == upgrade {
+				serveTLS(conn, cfg)
+				return
+			}
+		}
+	})
+}
+
+func TestLineStartTLSProtocols(t *testing.T) {
+	tests := []struct {
+		name     string
+		scan     func(ip, hostname string, port int) (*ScanResult, error)
+		greeting string
+		replies  map[string]string
+		upgrade  string // command after which TLS starts, STARTTLS if empty
+		wantErr  string
+	}{
+		{
+			name:     "nntp",
+			scan:     scanNNTPStartTLS,
+			greeting: "200 news.scanner.test ready\r\n",
+			replies: map[string]string{
+				"CAPABILITIES": "101 Capability list:\r\nVERSION 2\r\nREADER\r\nSTARTTLS\r\n.\r\n",
+				"STARTTLS":     "382 Continue with TLS negotiation\r\n",
+			},
+		},
+		{
+			name:     "nntp without STARTTLS",
+			scan:     scanNNTPStartTLS,
+			greeting: "201 news.scanner.test ready, no posting\r\n",
+			replies:  map[string]string{"CAPABILITIES": "101 Capability list:\r\nVERSION 2\r\nREADER\r\n.\r\n"},
+			wantErr:  "STARTTLS not supported",
+		},
+		{
+			name:     "sieve",
+			scan:     scanSieveStartTLS,
+			greeting: "\"IMPLEMENTATION\" \"Dovecot Pigeonhole\"\r\n\"SIEVE\" \"fileinto reject\"\r\n\"STARTTLS\"\r\n\"VERSION\" \"1.0\"\r\nOK \"ready\"\r\n",
+			replies:  map[string]string{"STARTTLS": "OK \"Begin TLS negotiation now\"\r\n"},
+		},
+		{
+			name:     "sieve without STARTTLS",
+			scan:     scanSieveStartTLS,
+			greeting: "\"IMPLEMENTATION\" \"Dovecot Pigeonhole\"\r\n\"SASL\" \"PLAIN\"\r\nOK \"ready\"\r\n",
+			wantErr:  "STARTTLS not supported",
+		},
+		{
+			name:     "irc",
+			scan:     scanIRCStartTLS,
+			greeting: ":irc.scanner.test NOTICE * :*** Looking up your hostname\r\n",
+			replies: map[string]string{
+				"CAP":      "PING :irc.scanner.test\r\n:irc.scanner.test CAP * LS * :multi-prefix sasl\r\n:irc.scanner.test CAP * LS :tls away-notify\r\n",
+				"PONG":     "",
+				"STARTTLS": ":irc.scanner.test 670 * :STARTTLS successful, go ahead\r\n",
+			},
+		},
+		{
+			name:     "irc STARTTLS refused",
+			scan:     scanIRCStartTLS,
+			greeting: "",
+			replies: map[string]string{
+				"CAP":      ":irc.scanner.test CAP * LS :tls\r\n",
+				"STARTTLS": ":irc.scanner.test 691 * :STARTTLS failed\r\n",
+			},
+			wantErr: "691",
+		},
+		{
+			name:     "lmtp",
+			scan:     scanLMTPStartTLS,
+			greeting: "220 lmtp.scanner.test LMTP ready\r\n",
+			replies: map[string]string{
+				"LHLO":     "250-lmtp.scanner.test\r\n250-PIPELINING\r\n250-ENHANCEDSTATUSCODES\r\n250 STARTTLS\r\n",
+				"STARTTLS": "220 2.0.0 Begin TLS\r\n",
+			},
+		},
+		{
+			name:     "lmtp without STARTTLS",
+			scan:     scanLMTPStartTLS,
+			greeting: "220 lmtp.scanner.test LMTP ready\r\n",
+			replies:  map[string]string{"LHLO": "250-lmtp.scanner.test\r\n250 PIPELINING\r\n"},
+			wantErr:  "STARTTLS not supported",
+		},
+		{
+			name:     "imap",
+			scan:     scanIMAPStartTLS,
+			greeting: "* OK [CAPABILITY IMAP4rev1 LITERAL+] Dovecot ready.\r\n",
+			replies: map[string]string{
+				"A001": "* CAPABILITY IMAP4rev1 SASL-IR STARTTLS LOGINDISABLED\r\na001 OK Pre-login capabilities listed\r\n",
+				"A002": "a002 OK Begin TLS negotiation now\r\n",
+			},
+			upgrade: "A002",
+		},
+		{
+			name:     "imap without STARTTLS",
+			scan:     scanIMAPStartTLS,
+			greeting: "* OK IMAP4rev1 ready\r\n",
+			replies:  map[string]string{"A001": "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\na001 OK done\r\n"},
+			wantErr:  "STARTTLS not supported",
+		},
+		{
+			name:     "imap STARTTLS refused",
+			scan:     scanIMAPStartTLS,
+			greeting: "* OK IMAP4rev1 ready\r\n",
+			replies: map[string]string{
+				"A001": "* CAPABILITY IMAP4rev1 STARTTLS\r\na001 OK done\r\n",
+				"A002": "* BYE going away\r\na002 NO TLS unavailable\r\n",
+			},
+			wantErr: "a002 NO",
+		},
+		{
+			name:     "imap PREAUTH greeting",
+			scan:     scanIMAPStartTLS,
+			greeting: "* PREAUTH IMAP4rev1 logged in\r\n",
+			wantErr:  "unexpected imap greeting",
+		},
+		{
+			name:     "pop3",
+			scan:     scanPOP3StartTLS,
+			greeting: "+OK Dovecot ready.\r\n",
+			replies: map[string]string{
+				"CAPA": "+OK\r\nCAPA\r\nTOP\r\nUIDL\r\nSTLS\r\nSASL PLAIN\r\n.\r\n",
+				"STLS": "+OK Begin TLS negotiation now.\r\n",
+			},
+			upgrade: "STLS",
+		},
+		{
+			name:     "pop3 without STLS",
+			scan:     scanPOP3StartTLS,
+			greeting: "+OK POP3 ready\r\n",
+			replies:  map[string]string{"CAPA": "+OK Capability list follows\r\nTOP\r\nUSER\r\n.\r\n"},
+			wantErr:  "STLS not supported",
+		},
+		{
+			name:     "pop3 without CAPA",
+			scan:     scanPOP3StartTLS,
+			greeting: "+OK POP3 ready\r\n",
+			replies:  map[string]string{"CAPA": "-ERR unknown command\r\n"},
+			wantErr:  "CAPA failed",
+		},
+		{
+			name:     "pop3 STLS refused",
+			scan:     scanPOP3StartTLS,
+			greeting: "+OK POP3 ready\r\n",
+			replies: map[string]string{
+				"CAPA": "+OK\r\nSTLS\r\n.\r\n",
+				"STLS": "-ERR TLS not available\r\n",
+			},
+			wantErr: "STLS failed",
+		},
+		{
+			name:     "ftp",
+			scan:     scanFTPAuthTLS,
+			greeting: "220-Welcome to the scanner.test FTP service\r\n  Unauthorized access prohibited\r\n220-\r\n220 ready\r\n",
+			replies:  map[string]string{"AUTH": "234 Proceed with negotiation.\r\n"},
+			upgrade:  "AUTH",
+		},
+		{
+			name:     "ftp without AUTH TLS",
+			scan:     scanFTPAuthTLS,
+			greeting: "220 ready\r\n",
+			replies:  map[string]string{"AUTH": "530-Please login with USER and PASS.\r\n530 AUTH not available\r\n"},
+			wantErr:  "AUTH TLS not supported",
+		},
+		{
+			name:     "ftp service unavailable",
+			scan:     scanFTPAuthTLS,
+			greeting: "421 Too many connections\r\n",
+			wantErr:  "unexpected ftp greeting",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t)
+			upgrade := tt.upgrade
+			if upgrade == "" {
+				upgrade = "STARTTLS"
+			}
+			ip, port := fakeLineServer(t, tt.greeting, tt.replies, upgrade)
+			result, err := tt.scan(ip, testHostname, port)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.TLSMode != tlsModeStartTLS || len(result.Certificates) != 1 {
+				t.Fatalf("unexpected result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestReadFTPReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantCode string
+		wantLine string
+		wantErr  bool
+	}{
+		{"single line", "220 ready\r\n", "220", "220 ready", false},
+		{"multi-line", "211-Features:\r\n AUTH TLS\r\n PBSZ\r\n211 End\r\n", "211", "211 End", false},
+		{"continuation lines with codes", "211-Status\r\n211-more\r\n200 other code\r\n211 End\r\n", "211", "211 End", false},
+		{"reply after the final line", "220 ready\r\n500 next\r\n", "220", "220 ready", false},
+		{"LF only", "234 go ahead\n", "234", "234 go ahead", false},
+		{"malformed", "22\r\n", "", "", true},
+		{"truncated multi-line", "211-Features:\r\n AUTH TLS\r\n", "", "", true},
+		{"empty", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &lineSession{reader: bufio.NewReader(strings.NewReader(tt.input))}
+			code, line, err := readFTPReply(s)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q %q", code, line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode || line != tt.wantLine {
+				t.Fatalf("got %q %q, want %q %q", code, line, tt.wantCode, tt.wantLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// tls13certs.go provides certificate recovery from encrypted TLS 1.3 handshakes for NextPKI.
+// When the TLS stack rejects a certificate it cannot handle (e.g. ML-DSA keys), the
+// server's handshake flight is decrypted with the logged handshake traffic secret and
+// the EncryptedExtensions, CertificateRequest and Certificate messages are parsed directly.
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TLS record, handshake and extension types used when decrypting the server's handshake flight.
+const (
+	recordTypeApplicationData = 23
+
+	handshakeTypeEncryptedExtensions = 8
+	handshakeTypeCertificate         = 11
+	handshakeTypeCertificateRequest  = 13
+
+	extensionStatusRequest          = 5
+	extensionALPN                   = 16
+	extensionSCT                    = 18
+	extensionCertificateAuthorities = 47
+)
+
+// serverHandshakeSecret returns the SERVER_HANDSHAKE_TRAFFIC_SECRET from an NSS key log.
+func serverHandshakeSecret(keyLog []byte) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(keyLog))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "SERVER_HANDSHAKE_TRAFFIC_SECRET" {
+			return hex.DecodeString(fields[2])
+		}
+	}
+	return nil, fmt.Errorf("no server handshake traffic secret logged")
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label with SHA-256 and an empty context (RFC 8446, 7.1).
+func hkdfExpandLabel(secret []byte, label string, length int) ([]byte, error) {
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(info, "tls13 "+label...)
+	info = append(info, 0)
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// decryptServerHandshake decrypts the encrypted records sent by the server with the
+// TLS_AES_128_GCM_SHA256 handshake keys and returns the plaintext handshake stream.
+func decryptServerHandshake(received, secret []byte) ([]byte, error) {
+	key, err := hkdfExpandLabel(secret, "key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hkdfExpandLabel(secret, "iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream []byte
+	var seq uint64
+	for len(received) >= 5 {
+		length := int(binary.BigEndian.Uint16(received[3:5]))
+		if len(received) < 5+length {
+			break
+		}
+		record := received[:5+length]
+		received = received[5+length:]
+		if record[0] != recordTypeApplicationData {
+			continue // ServerHello and ChangeCipherSpec are not encrypted
+		}
+
+		nonce := bytes.Clone(iv)
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+		}
+		seq++
+		plaintext, err := aead.Open(nil, nonce, record[5:], record[:5])
+		if err != nil {
+			// Records beyond the handshake flight use different keys
+			break
+		}
+		// Strip the padding and the inner content type (RFC 8446, 5.2)
+		plaintext = bytes.TrimRight(plaintext, "\x00")
+		if len(plaintext) == 0 || plaintext[len(plaintext)-1] != recordTypeHandshake {
+			continue
+		}
+		stream = append(stream, plaintext[:len(plaintext)-1]...)
+	}
+	return stream, nil
+}
+
+// tls13ServerFlight holds the data recovered from the encrypted handshake flight of a TLS 1.3 server.
+type tls13ServerFlight struct {
+	alpn          string   // protocol selected in EncryptedExtensions
+	certificates  [][]byte // DER certificates, leaf first
+	ocspResponse  []byte   // OCSP response stapled to the leaf entry
+	scts          [][]byte // SCTs delivered with the leaf entry
+	certRequested bool     // the server sent a CertificateRequest
+	acceptableCAs [][]byte // DER distinguished names of the certificate_authorities extension
+}
+
+// parseTLSExtensions splits a length-prefixed extension block into extension data by type.
+func parseTLSExtensions(block []byte) (map[uint16][]byte, error) {
+	if len(block) < 2 || len(block) < 2+int(binary.BigEndian.Uint16(block[0:2])) {
+		return nil, fmt.Errorf("short extension block")
+	}
+	data := block[2 : 2+int(binary.BigEndian.Uint16(block[0:2]))]
+	extensions := make(map[uint16][]byte)
+	for len(data) > 0 {
+		if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint16(data[2:4])) {
+			return nil, fmt.Errorf("short extension")
+		}
+		extLen := int(binary.BigEndian.Uint16(data[2:4]))
+		extensions[binary.BigEndian.Uint16(data[0:2])] = data[4 : 4+extLen]
+		data = data[4+extLen:]
+	}
+	return extensions, nil
+}
+
+// parseLengthPrefixedList splits a vector with a 2-byte length into its entries, each prefixed
+// with a length of entryLenBytes (1 or 2) bytes.
+func parseLengthPrefixedList(data []byte, entryLenBytes int) [][]byte {
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data[0:2])) {
+		return nil
+	}
+	data = data[2 : 2+int(binary.BigEndian.Uint16(data[0:2]))]
+	var entries [][]byte
+	for len(data) >= entryLenBytes {
+		n := int(data[0])
+		if entryLenBytes == 2 {
+			n = int(binary.BigEndian.Uint16(data[0:2]))
+		}
+		if len(data) < entryLenBytes+n {
+			break
+		}
+		entries = append(entries, data[entryLenBytes:entryLenBytes+n])
+		data = data[entryLenBytes+n:]
+	}
+	return entries
+}
+
+// parseTLS13Certificate reads the DER certificates of a TLS 1.3 Certificate message body,
+// and the stapled OCSP response and SCTs of the leaf entry.
+func parseTLS13Certificate(body []byte, flight *tls13ServerFlight) error {
+	if len(body) < 1 || len(body) < 1+int(body[0])+3 {
+		return fmt.Errorf("short Certificate message")
+	}
+	rest := body[1+int(body[0]):] // skip certificate_request_context
+	listLen := int(rest[0])<<16 | int(rest[1])<<8 | int(rest[2])
+	list := rest[3:]
+	if len(list) < listLen {
+		return fmt.Errorf("short certificate list")
+	}
+	list = list[:listLen]
+
+	for len(list) >= 3 {
+		certLen := int(list[0])<<16 | int(list[1])<<8 | int(list[2])
+		if len(list) < 3+certLen+2 {
+			return fmt.Errorf("short certificate entry")
+		}
+		flight.certificates = append(flight.certificates, list[3:3+certLen])
+		list = list[3+certLen:]
+		extLen := int(binary.BigEndian.Uint16(list[0:2]))
+		if len(list) < 2+extLen {
+			return fmt.Errorf("short certificate extensions")
+		}
+		if len(flight.certificates) == 1 {
+			extensions, err := parseTLSExtensions(list[:2+extLen])
+			if err != nil {
+				return err
+			}
+			// CertificateStatus: status_type ocsp (1), 3-byte length, OCSPResponse
+			if status := extensions[extensionStatusRequest]; len(status) >= 4 && status[0] == 1 {
+				flight.ocspResponse = status[4:]
+			}
+			flight.scts = parseLengthPrefixedList(extensions[extensionSCT], 2)
+		}
+		list = list[2+extLen:]
+	}
+	if len(flight.certificates) == 0 {
+		return fmt.Errorf("empty certificate list")
+	}
+	return nil
+}
+
+// parseTLS13CertificateRequest reads the certificate_authorities of a TLS 1.3 CertificateRequest body.
+func parseTLS13CertificateRequest(body []byte, flight *tls13ServerFlight) error {
+	flight.certRequested = true
+	if len(body) < 1 || len(body) < 1+int(body[0]) {
+		return fmt.Errorf("short CertificateRequest message")
+	}
+	extensions, err := parseTLSExtensions(body[1+int(body[0]):]) // skip certificate_request_context
+	if err != nil {
+		return err
+	}
+	flight.acceptableCAs = parseLengthPrefixedList(extensions[extensionCertificateAuthorities], 2)
+	return nil
+}
+
+// recoverTLS13Flight decrypts the server's handshake flight from the recorded bytes and key log
+// of an aborted TLS 1.3 handshake and returns the certificate chain and handshake metadata.
+func recoverTLS13Flight(received, keyLog []byte) (*tls13ServerFlight, error) {
+	secret, err := serverHandshakeSecret(keyLog)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := decryptServerHandshake(received, secret)
+	if err != nil {
+		return nil, err
+	}
+	flight := &tls13ServerFlight{}
+	for _, msg := range splitHandshakeMessages(stream) {
+		switch msg[0] {
+		case handshakeTypeEncryptedExtensions:
+			extensions, err := parseTLSExtensions(msg[4:])
+			if err != nil {
+				return nil, err
+			}
+			if protocols := parseLengthPrefixedList(extensions[extensionALPN], 1); len(protocols) == 1 {
+				flight.alpn = string(protocols[0])
+			}
+		case handshakeTypeCertificateRequest:
+			if err := parseTLS13CertificateRequest(msg[4:], flight); err != nil {
+				return nil, err
+			}
+		case handshakeTypeCertificate:
+			if err := parseTLS13Certificate(msg[4:], flight); err != nil {
+				return nil, err
+			}
+			return flight, nil
+		}
+	}
+	return nil, fmt.Errorf("no Certificate message in server handshake")
+}
@@ -0,0 +1,285 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"slices"
+	"testing"
+)
+
+// tls13TestSecret is the server handshake traffic secret of the synthetic handshakes.
+var tls13TestSecret = bytes.Repeat([]byte{0x5a}, 32)
+
+// tls13TestKeyLog is an NSS key log carrying tls13TestSecret.
+var tls13TestKeyLog = []byte("" +
+	"CLIENT_HANDSHAKE_TRAFFIC_SECRET " + hex.EncodeToString(make([]byte, 32)) + " " + hex.EncodeToString(make([]byte, 32)) + "\n" +
+	"SERVER_HANDSHAKE_TRAFFIC_SECRET " + hex.EncodeToString(make([]byte, 32)) + " " + hex.EncodeToString(tls13TestSecret) + "\n")
+
+// handshakeMessage frames a handshake message body.
+func handshakeMessage(msgType byte, body []byte) []byte {
+	length := len(body)
+	return append([]byte{msgType, byte(length >> 16), byte(length >> 8), byte(length)}, body...)
+}
+
+// vector prefixes data with a length of n bytes.
+func vector(n int, data ...[]byte) []byte {
+	joined := bytes.Join(data, nil)
+	length := make([]byte, n)
+	for i := range n {
+		length[n-1-i] = byte(len(joined) >> (8 * i))
+	}
+	return append(length, joined...)
+}
+
+// extension encodes a TLS extension.
+func extension(extType uint16, data []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, extType), vector(2, data)...)
+}
+
+// plaintextRecord frames data as a plaintext record of the given type.
+func plaintextRecord(recordType byte, data []byte) []byte {
+	return append([]byte{recordType, 3, 3, byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// tls13ServerHello builds a TLS 1.3 ServerHello record for the given suite.
+func tls13ServerHello(random []byte, suite uint16) []byte {
+	body := binary.BigEndian.AppendUint16(nil, tls.VersionTLS12)
+	body = append(body, random...)
+	body = append(body, 0) // session id
+	body = binary.BigEndian.AppendUint16(body, suite)
+	body = append(body, 0) // compression method
+	body = append(body, vector(2,
+		extension(extensionSupportedVersions, binary.BigEndian.AppendUint16(nil, tls.VersionTLS13)),
+		extension(extensionKeyShare, append(binary.BigEndian.AppendUint16(nil, uint16(tls.X25519)), vector(2, make([]byte, 32))...)),
+	)...)
+	return plaintextRecord(recordTypeHandshake, handshakeMessage(handshakeTypeServerHello, body))
+}
+
+// tls13Sealer encrypts records with the TLS_AES_128_GCM_SHA256 keys of a traffic secret.
+type tls13Sealer struct {
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+}
+
+func newTLS13Sealer(t *testing.T, secret []byte) *tls13Sealer {
+	t.Helper()
+	key, _ := hkdfExpandLabel(secret, "key", 16)
+	iv, _ := hkdfExpandLabel(secret, "iv", 12)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls13Sealer{aead: aead, iv: iv}
+}
+
+// seal encrypts data as one record with the inner content type and padding.
+func (s *tls13Sealer) seal(contentType byte, data []byte, padding int) []byte {
+	inner := append(append(bytes.Clone(data), contentType), make([]byte, padding)...)
+	header := []byte{recordTypeApplicationData, 3, 3, 0, 0}
+	binary.BigEndian.PutUint16(header[3:5], uint16(len(inner)+s.aead.Overhead()))
+	nonce := bytes.Clone(s.iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(s.seq >> (8 * i))
+	}
+	s.seq++
+	return s.aead.Seal(header, nonce, inner, header)
+}
+
+// tls13TestFlight builds the plaintext messages of a server flight with ALPN, a
+// CertificateRequest and a two-certificate chain whose leaf carries an OCSP response and an SCT.
+func tls13TestFlight(leaf, intermediate, caName []byte) [][]byte {
+	alpn := extension(extensionALPN, vector(2, vector(1, []byte("h2"))))
+	certRequest := append([]byte{0}, vector(2,
+		extension(13, vector(2, []byte{0x04, 0x03})), // signature_algorithms
+		extension(extensionCertificateAuthorities, vector(2, vector(2, caName))),
+	)...)
+	leafExtensions := vector(2,
+		extension(extensionStatusRequest, append([]byte{1}, vector(3, []byte("ocsp response"))...)),
+		extension(extensionSCT, vector(2, vector(2, []byte("sct")))),
+	)
+	certificate := append([]byte{0}, vector(3,
+		vector(3, leaf), leafExtensions,
+		vector(3, intermediate), vector(2),
+	)...)
+	return [][]byte{
+		handshakeMessage(handshakeTypeEncryptedExtensions, vector(2, alpn)),
+		handshakeMessage(handshakeTypeCertificateRequest, certRequest),
+		handshakeMessage(handshakeTypeCertificate, certificate),
+		handshakeMessage(15, make([]byte, 72)), // CertificateVerify
+		handshakeMessage(20, make([]byte, 32)), // Finished
+	}
+}
+
+func TestRecoverTLS13Flight(t *testing.T) {
+	leaf := testCertificate(t).Certificate[0]
+	intermediate := testCertificate(t).Certificate[0]
+	caName := []byte("0\x00")
+	messages := tls13TestFlight(leaf, intermediate, caName)
+	stream := bytes.Join(messages, nil)
+
+	tests := []struct {
+		name    string
+		records func(s *tls13Sealer) [][]byte
+	}{
+		{"one record per message", func(s *tls13Sealer) [][]byte {
+			var records [][]byte
+			for _, msg := range messages {
+				records = append(records, s.seal(recordTypeHandshake, msg, 0))
+			}
+			return records
+		}},
+		{"coalesced", func(s *tls13Sealer) [][]byte {
+			return [][]byte{s.seal(recordTypeHandshake, stream, 0)}
+		}},
+		{"fragmented and padded", func(s *tls13Sealer) [][]byte {
+			var records [][]byte
+			for chunk := range slices.Chunk(stream, 100) {
+				records = append(records, s.seal(recordTypeHandshake, chunk, 16))
+			}
+			return records
+		}},
+		{"followed by application data", func(s *tls13Sealer) [][]byte {
+			other := newTLS13Sealer(t, bytes.Repeat([]byte{0x11}, 32))
+			return [][]byte{s.seal(recordTypeHandshake, stream, 0), other.seal(recordTypeApplicationData, []byte("data"), 0)}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := tls13ServerHello(make([]byte, 32), tls.TLS_AES_128_GCM_SHA256)
+			received = append(received, plaintextRecord(recordTypeChangeCipherSpec, []byte{1})...)
+			for _, record := range tt.records(newTLS13Sealer(t, tls13TestSecret)) {
+				received = append(received, record...)
+			}
+
+			flight, err := recoverTLS13Flight(received, tls13TestKeyLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(flight.certificates, [][]byte{leaf, intermediate}, bytes.Equal) {
+				t.Fatalf("got %d certificates, want the leaf and the intermediate", len(flight.certificates))
+			}
+			if flight.alpn != "h2" || string(flight.ocspResponse) != "ocsp response" {
+				t.Fatalf("got ALPN %q, OCSP response %q", flight.alpn, flight.ocspResponse)
+			}
+			if len(flight.scts) != 1 || string(flight.scts[0]) != "sct" {
+				t.Fatalf("got SCTs %q", flight.scts)
+			}
+			if !flight.certRequested || len(flight.acceptableCAs) != 1 || !bytes.Equal(flight.acceptableCAs[0], caName) {
+				t.Fatalf("got CertificateRequest %v with CAs %q", flight.certRequested, flight.acceptableCAs)
+			}
+		})
+	}
+}
+
+func TestRecoverTLS13FlightWrongKeyLog(t *testing.T) {
+	leaf := testCertificate(t).Certificate[0]
+	received := tls13ServerHello(make([]byte, 32), tls.TLS_AES_128_GCM_SHA256)
+	received = append(received, newTLS13Sealer(t, tls13TestSecret).seal(recordTypeHandshake, bytes.Join(tls13TestFlight(leaf, leaf, nil), nil), 0)...)
+
+	otherKeyLog := bytes.ReplaceAll(tls13TestKeyLog, []byte(hex.EncodeToString(tls13TestSecret)), []byte(hex.EncodeToString(make([]byte, 32))))
+	if _, err := recoverTLS13Flight(received, otherKeyLog); err == nil {
+		t.Fatal("expected error for a key log of another handshake")
+	}
+	if _, err := recoverTLS13Flight(received, []byte("CLIENT_RANDOM 00 00\n")); err == nil {
+		t.Fatal("expected error for a key log without handshake secret")
+	}
+}
+
+// recordedTLS13Handshake completes a handshake between crypto/tls peers and returns the bytes
+// sent by the server, the client's key log and the server's chain.
+func recordedTLS13Handshake(t *testing.T) ([]byte, []byte, [][]byte) {
+	t.Helper()
+	cfg := testTLSConfig(t)
+	cfg.ClientAuth = tls.RequestClientCert
+	cfg.NextProtos = []string{"h2"}
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		tls.Server(serverConn, cfg).Handshake()
+	}()
+
+	var keyLog bytes.Buffer
+	rc := &recordingConn{Conn: clientConn}
+	client := tls.Client(rc, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		NextProtos:         []string{"h2"},
+		KeyLogWriter:       &keyLog,
+	})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if suite := client.ConnectionState().CipherSuite; suite != tls.TLS_AES_128_GCM_SHA256 {
+		t.Skipf("server selected %s", tls.CipherSuiteName(suite))
+	}
+	return rc.received.Bytes(), keyLog.Bytes(), cfg.Certificates[0].Certificate
+}
+
+func TestRecoverTLS13FlightRecordedHandshake(t *testing.T) {
+	received, keyLog, chain := recordedTLS13Handshake(t)
+	flight, err := recoverTLS13Flight(received, keyLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(flight.certificates, chain, bytes.Equal) {
+		t.Fatal("recovered chain differs from the server's chain")
+	}
+	if flight.alpn != "h2" || !flight.certRequested {
+		t.Fatalf("got ALPN %q, CertificateRequest %v", flight.alpn, flight.certRequested)
+	}
+	if version, suite, ok := serverHelloSelection(received); !ok || version != tls.VersionTLS13 || suite != tls.TLS_AES_128_GCM_SHA256 {
+		t.Fatalf("got ServerHello %s %s", tls.VersionName(version), tls.CipherSuiteName(suite))
+	}
+}
+
+func TestServerHelloSelection(t *testing.T) {
+	hrr := tls13ServerHello(helloRetryRequestRandom, tls.TLS_AES_256_GCM_SHA384)
+	serverHello := tls13ServerHello(make([]byte, 32), tls.TLS_AES_128_GCM_SHA256)
+
+	version, suite, ok := serverHelloSelection(append(hrr, serverHello...))
+	if !ok || version != tls.VersionTLS13 || suite != tls.TLS_AES_128_GCM_SHA256 {
+		t.Fatalf("got %v %s %s", ok, tls.VersionName(version), tls.CipherSuiteName(suite))
+	}
+	if _, _, ok := serverHelloSelection(hrr); ok {
+		t.Fatal("HelloRetryRequest reported as ServerHello")
+	}
+}
+
+func TestIsMLDSAKey(t *testing.T) {
+	spki := func(oid asn1.ObjectIdentifier) *x509.Certificate {
+		der, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{pkix.AlgorithmIdentifier{Algorithm: oid}, asn1.BitString{Bytes: make([]byte, 16), BitLength: 128}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &x509.Certificate{RawSubjectPublicKeyInfo: der}
+	}
+	for _, oid := range []asn1.ObjectIdentifier{oidMLDSA44, oidMLDSA65, oidMLDSA87} {
+		if !isMLDSAKey(spki(oid)) {
+			t.Errorf("%v not recognized as ML-DSA", oid)
+		}
+	}
+	ecdsaLeaf, err := x509.ParseCertificate(testCertificate(t).Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMLDSAKey(ecdsaLeaf) {
+		t.Fatal("ECDSA fallback certificate recognized as ML-DSA")
+	}
+}
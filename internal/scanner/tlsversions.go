@@ -0,0 +1,181 @@
+// tlsversions.go provides TLS protocol version enumeration for NextPKI.
+// Each version from TLS 1.0 to TLS 1.3 is offered on its own, so servers that still
+// accept legacy versions alongside modern ones can be identified.
+package scanner
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+	utls "github.com/refraction-networking/utls"
+)
+
+// probedTLSVersions lists the protocol versions tested by enumerateTLSVersions, oldest first.
+var probedTLSVersions = []uint16{utls.VersionTLS10, utls.VersionTLS11, utls.VersionTLS12, utls.VersionTLS13}
+
+// legacyCipherSuites are offered by handshakes that must complete with TLS 1.0 to 1.2
+// (e.g. key exchange detection). They cover ECDSA and RSA keys and include the CBC suites
+// required by TLS 1.0 and 1.1.
+var legacyCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+}
+
+// tls13CipherSuites are offered when probing TLS 1.3.
+var tls13CipherSuites = []uint16{
+	tls.TLS_AES_128_GCM_SHA256,
+	tls.TLS_AES_256_GCM_SHA384,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// probeSignatureAlgorithms covers ECDSA, RSA PKCS#1 v1.5 and RSA-PSS, including SHA-1 for legacy servers.
+var probeSignatureAlgorithms = []utls.SignatureScheme{
+	utls.ECDSAWithP256AndSHA256,
+	utls.ECDSAWithP384AndSHA384,
+	utls.ECDSAWithP521AndSHA512,
+	utls.PSSWithSHA256,
+	utls.PSSWithSHA384,
+	utls.PSSWithSHA512,
+	utls.PKCS1WithSHA256,
+	utls.PKCS1WithSHA384,
+	utls.PKCS1WithSHA512,
+	utls.ECDSAWithSHA1,
+	utls.PKCS1WithSHA1,
+}
+
+// tlsVersionEnumerationEnabled reports whether version enumeration is enabled globally
+// or for the include_list entry matching the target.
+func tlsVersionEnumerationEnabled(ip, hostname string, port int) bool {
+	if shared.Config.EnumerateTLSVersions {
+		return true
+	}
+	entry := includeEntryFor(ip, hostname, port)
+	return entry != nil && entry.EnumerateTLSVersions
+}
+
+// probeSpec builds a ClientHello offering the given version range and cipher suites.
+// TLS 1.3 specific extensions are added when maxVersion is TLS 1.3.
+func probeSpec(hostname string, minVersion, maxVersion uint16, suites []uint16) *utls.ClientHelloSpec {
+	extensions := []utls.TLSExtension{
+		&utls.SNIExtension{ServerName: hostname},
+		&utls.SupportedCurvesExtension{Curves: []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}},
+		&utls.SupportedPointsExtension{SupportedPoints: []byte{0}}, // uncompressed
+		&utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: probeSignatureAlgorithms},
+	}
+	if maxVersion == utls.VersionTLS13 {
+		var versions []uint16
+		for v := maxVersion; v >= minVersion; v-- {
+			versions = append(versions, v)
+		}
+		extensions = append(extensions,
+			&utls.SupportedVersionsExtension{Versions: versions},
+			&utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: utls.X25519}}},
+			&utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}},
+		)
+	}
+	return &utls.ClientHelloSpec{
+		TLSVersMin:   minVersion,
+		TLSVersMax:   maxVersion,
+		CipherSuites: suites,
+		Extensions:   extensions,
+	}
+}
+
+// versionProbeSpec builds a ClientHello that offers exactly one TLS protocol version.
+// TLS 1.0 to 1.2 probes offer the full legacy suite set, so servers restricted to suites
+// such as DHE or TLS 1.0-era ciphers are still detected.
+func versionProbeSpec(hostname string, version uint16) *utls.ClientHelloSpec {
+	legacy, tls13 := enumerableCipherSuites()
+	if version == utls.VersionTLS13 {
+		return probeSpec(hostname, version, version, tls13)
+	}
+	return probeSpec(hostname, version, version, legacy)
+}
+
+// probeHandshake dials the target, runs the optional preamble and performs a handshake with spec.
+// Returns the protocol version and cipher suite selected by the server. The ServerHello is
+// sufficient, so suites the TLS stack cannot complete a handshake with (e.g. DHE) are reported.
+func probeHandshake(ip, hostname string, port int, spec *utls.ClientHelloSpec, preamble connPreamble) (version, suite uint16, err error) {
+	state, received, err := recordedHandshake(ip, hostname, port, spec, preamble)
+	if err == nil {
+		return state.Version, state.CipherSuite, nil
+	}
+	if version, suite, ok := serverHelloSelection(received); ok {
+		return version, suite, nil
+	}
+	return 0, 0, err
+}
+
+// recordedHandshake performs a handshake like probeHandshake and additionally returns the raw
+// bytes the server sent during the handshake (after the preamble), also if the handshake failed.
+func recordedHandshake(ip, hostname string, port int, spec *utls.ClientHelloSpec, preamble connPreamble) (utls.ConnectionState, []byte, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, configuredDialTimeout())
+	if err != nil {
+		return utls.ConnectionState{}, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(starttlsIOTimeout))
+
+	if preamble != nil {
+		if err := preamble(conn); err != nil {
+			return utls.ConnectionState{}, nil, err
+		}
+	}
+
+	rc := &recordingConn{Conn: conn}
+	uconn := utls.UClient(rc, &utls.Config{ServerName: hostname, InsecureSkipVerify: true}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		return utls.ConnectionState{}, nil, err
+	}
+	if err := uconn.Handshake(); err != nil {
+		return utls.ConnectionState{}, rc.received.Bytes(), err
+	}
+	return uconn.ConnectionState(), rc.received.Bytes(), nil
+}
+
+// probeTLSVersion performs a handshake that only offers the given TLS version.
+// Returns nil if the server selected exactly that version.
+func probeTLSVersion(ip, hostname string, port int, version uint16, preamble connPreamble) error {
+	negotiated, _, err := probeHandshake(ip, hostname, port, versionProbeSpec(hostname, version), preamble)
+	if err != nil {
+		return err
+	}
+	if negotiated != version {
+		return fmt.Errorf("server negotiated %s", tls.VersionName(negotiated))
+	}
+	return nil
+}
+
+// enumerateTLSVersions probes each TLS version separately and returns the names of the
+// versions the server accepted (e.g. "TLS 1.2"), oldest first.
+func enumerateTLSVersions(ip, hostname string, port int, preamble connPreamble) []string {
+	var supported []string
+	for _, version := range probedTLSVersions {
+		if err := probeTLSVersion(ip, hostname, port, version, preamble); err != nil {
+			logutil.DebugLog("%s not accepted by %s:%d: %v", tls.VersionName(version), ip, port, err)
+			continue
+		}
+		supported = append(supported, tls.VersionName(version))
+	}
+	return supported
+}
@@ -0,0 +1,20 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestEnumerateTLSVersionsTLS10Only(t *testing.T) {
+	useTestConfig(t)
+	cfg := testTLSConfig(t)
+	cfg.MinVersion = tls.VersionTLS10
+	cfg.MaxVersion = tls.VersionTLS10
+	ip, port := listenTCP(t, func(conn net.Conn) { serveTLS(conn, cfg) })
+
+	if got := enumerateTLSVersions(ip, testHostname, port, nil); !slices.Equal(got, []string{"TLS 1.0"}) {
+		t.Fatalf("got %v, want [TLS 1.0]", got)
+	}
+}
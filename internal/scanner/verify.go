@@ -0,0 +1,180 @@
+// verify.go provides certificate chain validation for NextPKI.
+// Handshakes skip verification so every chain can be collected. Afterwards, each chain is
+// verified against the system roots plus the configured root and intermediate bundles,
+// which tells whether browsers and other clients would accept the endpoint.
+package scanner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nextpki/certscan/internal/logutil"
+	"github.com/nextpki/certscan/internal/shared"
+)
+
+// Failure reasons reported in ChainValidation.Reason.
+const (
+	validationExpired          = "expired"
+	validationNotYetValid      = "not_yet_valid"
+	validationUnknownAuthority = "unknown_authority"
+	validationNameMismatch     = "name_mismatch"
+	validationWrongEKU         = "wrong_eku"
+	validationInvalid          = "invalid"
+)
+
+// ChainValidation describes the verification verdict for a collected chain.
+type ChainValidation struct {
+	Valid  bool     `json:"valid"`            // Whether a path to a trusted root was built and the leaf matches the name
+	Reason string   `json:"reason,omitempty"` // Optional: expired, not_yet_valid, unknown_authority, name_mismatch, wrong_eku or invalid
+	Error  string   `json:"error,omitempty"`  // Optional: verification error message
+	Path   []string `json:"path,omitempty"`   // Optional: subjects of the built path, leaf first, ending with the root
+}
+
+var (
+	trustStoreOnce     sync.Once
+	trustRoots         *x509.CertPool
+	trustIntermediates *x509.CertPool
+)
+
+// loadPEMBundle adds the certificates of a PEM file to pool.
+func loadPEMBundle(pool *x509.CertPool, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logutil.ErrorLog("Failed to read certificate bundle %s: %v", path, err)
+		return
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		logutil.ErrorLog("No certificates found in bundle %s", path)
+	}
+}
+
+// trustStore returns the root pool (system roots plus trusted_roots) and the pool of
+// trusted_intermediates. Both are loaded once on first use.
+func trustStore() (roots, intermediates *x509.CertPool) {
+	trustStoreOnce.Do(func() {
+		var err error
+		trustRoots, err = x509.SystemCertPool()
+		if err != nil {
+			logutil.ErrorLog("Failed to load system roots: %v", err)
+			trustRoots = x509.NewCertPool()
+		}
+		for _, path := range shared.Config.TrustedRoots {
+			loadPEMBundle(trustRoots, path)
+		}
+		trustIntermediates = x509.NewCertPool()
+		for _, path := range shared.Config.TrustedIntermediates {
+			loadPEMBundle(trustIntermediates, path)
+		}
+	})
+	return trustRoots, trustIntermediates
+}
+
+// pathValidityReason follows the issuer path through chain, starting at the leaf, and returns
+// not_yet_valid or expired for the first certificate outside its validity period, or an empty
+// string if all certificates on the path are valid at now.
+func pathValidityReason(chain []*x509.Certificate, now time.Time) string {
+	cert := chain[0]
+	for range chain {
+		if now.Before(cert.NotBefore) {
+			return validationNotYetValid
+		}
+		if now.After(cert.NotAfter) {
+			return validationExpired
+		}
+		idx := slices.IndexFunc(chain, func(issuer *x509.Certificate) bool {
+			return issuer != cert && bytes.Equal(issuer.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(issuer) == nil
+		})
+		if idx < 0 {
+			break
+		}
+		cert = chain[idx]
+	}
+	return ""
+}
+
+// validationReason maps a verification error to a failure reason. crypto/x509 reports an
+// intermediate outside its validity period as an unknown authority, so the path through
+// the chain is checked for validity periods in both cases.
+func validationReason(err error, chain []*x509.Certificate, now time.Time) string {
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		if reason := pathValidityReason(chain, now); reason != "" {
+			return reason
+		}
+		return validationExpired
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.IncompatibleUsage:
+		return validationWrongEKU
+	case errors.As(err, &authorityErr):
+		if reason := pathValidityReason(chain, now); reason != "" {
+			return reason
+		}
+		return validationUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		return validationNameMismatch
+	}
+	return validationInvalid
+}
+
+// verifyChain verifies a chain as sent by the server (leaf first) for serverAuth and the given
+// name, using the sent intermediates and the trust store.
+// Parameters:
+//
+//	certs: Chain sent by the server, leaf first
+//	name:  Server name the chain must be valid for (SNI, or the IP address if no SNI was sent)
+//
+// Returns: Validation verdict (nil for an empty chain)
+func verifyChain(certs []*x509.Certificate, name string) *ChainValidation {
+	if len(certs) == 0 {
+		return nil
+	}
+	roots, trusted := trustStore()
+	intermediates := trusted.Clone()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	now := time.Now()
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return &ChainValidation{
+			Reason: validationReason(err, certs, now),
+			Error:  err.Error(),
+		}
+	}
+	validation := &ChainValidation{Valid: true}
+	for _, cert := range chains[0] {
+		validation.Path = append(validation.Path, cert.Subject.String())
+	}
+	return validation
+}
+
+// checkChain completes a chain with the intermediates missing from it (see completeChain)
+// and verifies the completed chain for the target.
+// Returns: The fetched intermediates and the validation verdict
+func checkChain(certs []*x509.Certificate, ip, hostname string) ([]*x509.Certificate, *ChainValidation) {
+	fetched := completeChain(certs)
+	return fetched, verifyChain(append(slices.Clone(certs), fetched...), verificationName(ip, hostname))
+}
+
+// verificationName returns the name a chain is verified for: the SNI, or the IP address
+// if no SNI was sent.
+func verificationName(ip, hostname string) string {
+	if sni := sniValue(hostname); sni != "" {
+		return sni
+	}
+	return ip
+}
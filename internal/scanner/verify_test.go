@@ -0,0 +1,52 @@
+package scanner
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestValidationReason(t *testing.T) {
+	now := time.Now()
+	root := newTestIssuer(t, "Test Root")
+	valid := root.subordinate(t, "Valid Intermediate", now.Add(-time.Hour), now.Add(time.Hour))
+	future := root.subordinate(t, "Future Intermediate", now.Add(time.Hour), now.Add(2*time.Hour))
+	expired := root.subordinate(t, "Expired Intermediate", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		host  string
+		want  string
+	}{
+		{"leaf not yet valid", []*x509.Certificate{valid.issue(t, &x509.Certificate{NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour)}), valid.cert}, testHostname, validationNotYetValid},
+		{"leaf expired", []*x509.Certificate{valid.issue(t, &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}), valid.cert}, testHostname, validationExpired},
+		{"intermediate not yet valid", []*x509.Certificate{future.issue(t, &x509.Certificate{}), future.cert}, testHostname, validationNotYetValid},
+		{"intermediate expired", []*x509.Certificate{expired.issue(t, &x509.Certificate{}), expired.cert}, testHostname, validationExpired},
+		{"intermediate missing", []*x509.Certificate{valid.issue(t, &x509.Certificate{})}, testHostname, validationUnknownAuthority},
+		{"name mismatch", []*x509.Certificate{valid.issue(t, &x509.Certificate{}), valid.cert}, "other.test", validationNameMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intermediates := x509.NewCertPool()
+			for _, cert := range tt.chain[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := tt.chain[0].Verify(x509.VerifyOptions{
+				DNSName:       tt.host,
+				Roots:         roots,
+				Intermediates: intermediates,
+				CurrentTime:   now,
+			})
+			if err == nil {
+				t.Fatal("chain verified unexpectedly")
+			}
+			if got := validationReason(err, tt.chain, now); got != tt.want {
+				t.Fatalf("got %q, want %q (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// xmpp.go provides the XMPP STARTTLS scan logic and protocol handlers for NextPKI.
+// It implements certificate extraction for client-to-server (jabber:client, port 5222)
+// and server-to-server (jabber:server, port 5269) streams, and for direct TLS on port 5223.
+package scanner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// xmppsPort is the conventional port for XMPP client connections over direct TLS (XEP-0368).
+const xmppsPort = 5223
+
+// XMPP stream namespaces (RFC 6120).
+const (
+	xmppNSStreams = "http://etherx.jabber.org/streams"
+	xmppNSTLS     = "urn:ietf:params:xml:ns:xmpp-tls"
+	xmppNSClient  = "jabber:client"
+	xmppNSServer  = "jabber:server"
+)
+
+// nextXMPPStartElement returns the next start element from the stream.
+// A stream error or closed stream is reported as an error.
+func nextXMPPStartElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space == xmppNSStreams && t.Name.Local == "error" {
+				return xml.StartElement{}, fmt.Errorf("xmpp stream error")
+			}
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Space == xmppNSStreams && t.Name.Local == "stream" {
+				return xml.StartElement{}, fmt.Errorf("xmpp stream closed by server")
+			}
+		}
+	}
+}
+
+// scanXMPPStartTLS opens an XMPP stream addressed to hostname, waits for <starttls/> in the
+// stream features, negotiates STARTTLS and extracts certificates, sending sni as server name.
+// The namespace selects client-to-server (jabber:client) or server-to-server (jabber:server) mode.
+// Returns a ScanResult with certificate data or an error.
+func scanXMPPStartTLS(ip, hostname, sni string, port int, namespace string) (*ScanResult, error) {
+	// The stream is XML rather than lines, but the session provides the dial, the deadline
+	// and the buffered reader the decoder reads from
+	s, err := dialLineSession(ip, port)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var to bytes.Buffer
+	xml.EscapeText(&to, []byte(hostname))
+	fmt.Fprintf(s.conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='%s' xmlns:stream='%s' version='1.0'>", to.String(), namespace, xmppNSStreams)
+
+	decoder := xml.NewDecoder(s.reader)
+	stream, err := nextXMPPStartElement(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("xmpp stream header failed: %w", err)
+	}
+	if stream.Name.Space != xmppNSStreams || stream.Name.Local != "stream" {
+		return nil, fmt.Errorf("unexpected xmpp stream header <%s>", stream.Name.Local)
+	}
+	features, err := nextXMPPStartElement(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("xmpp stream features failed: %w", err)
+	}
+	if features.Name.Space != xmppNSStreams || features.Name.Local != "features" {
+		return nil, fmt.Errorf("unexpected xmpp element <%s>, expected stream features", features.Name.Local)
+	}
+
+	// Walk the children of <stream:features> looking for <starttls/>
+	supportsStartTLS := false
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("xmpp stream features failed: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space == xmppNSTLS && t.Name.Local == "starttls" {
+				supportsStartTLS = true
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if !supportsStartTLS {
+		return nil, fmt.Errorf("STARTTLS not offered by %s", ip)
+	}
+
+	fmt.Fprintf(s.conn, "<starttls xmlns='%s'/>", xmppNSTLS)
+	reply, err := nextXMPPStartElement(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("STARTTLS read failed: %w", err)
+	}
+	if reply.Name.Space != xmppNSTLS || reply.Name.Local != "proceed" {
+		return nil, fmt.Errorf("STARTTLS failed: <%s>", reply.Name.Local)
+	}
+
+	// Upgrade connection
+	return s.upgrade(ip, sni, port)
+}
+
+// xmppProtocolHandler is a ProtocolHandler for XMPP client-to-server scanning.
+// Port 5223 is scanned with a direct TLS handshake; all other ports use STARTTLS.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func xmppProtocolHandler(ip, hostname string, port int) []ScanResult {
+	if port == xmppsPort {
+		return defaultTLSHandler(ip, hostname, port, "xmpp")
+	}
+	return startTLSResults("XMPP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanXMPPStartTLS(ip, hostname, sni, port, xmppNSClient)
+	})
+}
+
+// xmppServerProtocolHandler is a ProtocolHandler for XMPP server-to-server scanning.
+// It returns the results to send to the webhook, or nil if the scan failed.
+func xmppServerProtocolHandler(ip, hostname string, port int) []ScanResult {
+	return startTLSResults("XMPP", ip, hostname, port, func(sni string) (*ScanResult, error) {
+		return scanXMPPStartTLS(ip, hostname, sni, port, xmppNSServer)
+	})
+}
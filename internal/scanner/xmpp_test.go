@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeXMPPServer answers the client's stream header with features and, if the client asks
+// for STARTTLS, with reply. After <proceed/> it starts TLS. The stream header received from
+// the client is sent to headers.
+func fakeXMPPServer(t *testing.T, features, reply string, headers chan<- xml.StartElement) (string, int) {
+	cfg := testTLSConfig(t)
+	return listenTCP(t, func(conn net.Conn) {
+		decoder := xml.NewDecoder(conn)
+		stream, err := nextXMPPStartElement(decoder)
+		if err != nil {
+			return
+		}
+		headers <- stream
+		var namespace string
+		for _, attr := range stream.Attr {
+			if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				namespace = attr.Value
+			}
+		}
+		fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream from='%s' id='c2s-1' xmlns='%s' xmlns:stream='%s' version='1.0'>%s",
+			testHostname, namespace, xmppNSStreams, features)
+		request, err := nextXMPPStartElement(decoder)
+		if err != nil || request.Name.Space != xmppNSTLS || request.Name.Local != "starttls" {
+			return
+		}
+		fmt.Fprint(conn, reply)
+		if strings.Contains(reply, "<proceed") {
+			serveTLS(conn, cfg)
+		}
+	})
+}
+
+func TestScanXMPPStartTLS(t *testing.T) {
+	const (
+		starttls  = "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>"
+		sasl      = "<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism><mechanism>SCRAM-SHA-1</mechanism></mechanisms>"
+		proceed   = "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"
+		failure   = "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:stream>"
+		streamErr = "<stream:error><host-unknown xmlns='urn:ietf:params:xml:ns:xmpp-streams'/></stream:error>"
+	)
+	tests := []struct {
+		name      string
+		namespace string
+		features  string
+		reply     string
+		wantErr   string
+	}{
+		{"client STARTTLS", xmppNSClient, "<stream:features>" + sasl + starttls + "</stream:features>", proceed, ""},
+		{"server STARTTLS", xmppNSServer, "<stream:features>" + starttls + "<dialback xmlns='urn:xmpp:features:dialback'/></stream:features>", proceed, ""},
+		{"STARTTLS not offered", xmppNSClient, "<stream:features>" + sasl + "</stream:features>", "", "STARTTLS not offered"},
+		{"STARTTLS failure", xmppNSClient, "<stream:features>" + starttls + "</stream:features>", failure, "STARTTLS failed: <failure>"},
+		{"stream error", xmppNSClient, streamErr, "", "stream error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t)
+			headers := make(chan xml.StartElement, 1)
+			ip, port := fakeXMPPServer(t, tt.features, tt.reply, headers)
+			result, err := scanXMPPStartTLS(ip, "chat.scanner.test", "", port, tt.namespace)
+
+			header := <-headers
+			attrs := map[string]string{}
+			for _, attr := range header.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			if attrs["to"] != "chat.scanner.test" || attrs["xmlns"] != tt.namespace || attrs["version"] != "1.0" {
+				t.Fatalf("unexpected stream header attributes: %v", attrs)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.TLSMode != tlsModeStartTLS || len(result.Certificates) != 1 {
+				t.Fatalf("unexpected result: %+v", result)
+			}
+		})
+	}
+}
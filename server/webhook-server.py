@@ -55,7 +55,7 @@ class WebhookHandler(http.server.BaseHTTPRequestHandler):
             machine_id = payload.get("machine_id", "unknown-machine-id")
             data = payload.get("scan_results", [])
 
-            logging.info(f"Webhook received from {primary_ip}/{machine_id} ({len(data)} certificates)")
+            logging.info(f"Webhook received from {primary_ip}/{machine_id} ({len(data)} certificates, schema v{payload.get('schema_version', 1)})")
 
             for entry in data:
                 try:
@@ -64,6 +64,63 @@ class WebhookHandler(http.server.BaseHTTPRequestHandler):
                     # Display handshake_type, http_headers, and timestamp if present
                     if 'handshake_type' in entry:
                         logging.info(f"    Handshake:  {entry['handshake_type']}")
+                    if 'sni' in entry:
+                        logging.info(f"    SNI:        {entry['sni'] or '(none)'}")
+                    if len(entry.get('sni_names', [])) > 1:
+                        logging.info(f"    Same chain: {', '.join(n or '(none)' for n in entry['sni_names'])}")
+                    if len(entry.get('handshake_types', [])) > 1:
+                        logging.info(f"    Same chain: {', '.join(entry['handshake_types'])}")
+                    if 'tls_mode' in entry:
+                        logging.info(f"    TLS Mode:   {entry['tls_mode']}")
+                    if entry.get('tls_mode_fallback'):
+                        logging.info("    Fallback:   no SMTP banner, implicit TLS used")
+                    if 'tls_version' in entry:
+                        logging.info(f"    TLS:        {entry['tls_version']}")
+                    if 'supported_tls_versions' in entry:
+                        logging.info(f"    Versions:   {', '.join(entry['supported_tls_versions'])}")
+                    if 'cipher_suite' in entry:
+                        logging.info(f"    Cipher:     {entry['cipher_suite']}")
+                    if 'alpn' in entry:
+                        logging.info(f"    ALPN:       {entry['alpn']}")
+                    if 'key_exchange_group' in entry:
+                        logging.info(f"    KEX Group:  {entry['key_exchange_group']}")
+                    if entry.get('resumed'):
+                        logging.info(f"    Resumed:    yes")
+                    if 'handshake_latency_ms' in entry:
+                        logging.info(f"    Latency:    {entry['handshake_latency_ms']} ms")
+                    for suite in entry.get('cipher_suites', []):
+                        weaknesses = ', '.join(suite.get('weaknesses', [])) or 'ok'
+                        logging.info(f"    Accepts:    {suite['name']} ({suite['version']}, {weaknesses})")
+                    if 'key_exchange' in entry:
+                        kex = entry['key_exchange']
+                        logging.info(f"    Group:      {kex.get('group', 'n/a')} (post-quantum: {kex['post_quantum']}, HRR: {kex.get('hello_retry_request', False)})")
+                    if 'ocsp_staple' in entry:
+                        staple = entry['ocsp_staple']
+                        stale = ' (stale)' if staple.get('stale') else ''
+                        logging.info(f"    OCSP:       {staple['status']}, next update {staple.get('next_update', 'n/a')}{stale}")
+                    for sct in entry.get('scts', []):
+                        logging.info(f"    SCT:        {sct['source']} log {sct['log_id']} at {sct['timestamp']}")
+                    if entry.get('chain_incomplete'):
+                        logging.info(f"    Chain:      incomplete ({entry.get('fetched_intermediates', 0)} intermediates fetched via AIA)")
+                    if 'validation' in entry:
+                        validation = entry['validation']
+                        if validation['valid']:
+                            logging.info(f"    Valid:      yes ({' -> '.join(validation.get('path', []))})")
+                        else:
+                            logging.info(f"    Valid:      no ({validation.get('reason')}: {validation.get('error', '')})")
+                    for rev in entry.get('revocation', []):
+                        detail = rev.get('reason') or rev.get('error', '')
+                        logging.info(f"    Revocation: {rev['subject']}: {rev['status']} {rev.get('source', '')} {detail}".rstrip())
+                    if entry.get('client_cert_requested'):
+                        logging.info(f"    Client cert requested (mTLS)")
+                    for ca in entry.get('acceptable_cas', []):
+                        logging.info(f"    Accepted CA: {ca}")
+                    if 'server_version' in entry:
+                        logging.info(f"    Server:     {entry['server_version']}")
+                    if 'service_type' in entry:
+                        logging.info(f"    Service:    {entry['service_type']}")
+                    if 'detected_protocol' in entry:
+                        logging.info(f"    Detected:   {entry['detected_protocol']}")
                     if 'timestamp' in entry:
                         logging.info(f"    Timestamp:  {entry['timestamp']}")
                     if 'http_headers' in entry and entry['http_headers']: